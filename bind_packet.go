@@ -0,0 +1,139 @@
+package wgnet
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/linkdata/deadlock"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// PacketListenFunc returns a net.PacketConn listening on the given UDP port.
+// If port is zero, a port is chosen automatically.
+type PacketListenFunc func(port uint16) (net.PacketConn, error)
+
+// PacketBind is a conn.Bind that sends and receives WireGuard datagrams
+// using a net.PacketConn, such as one provided by another WgNet.
+type PacketBind struct {
+	listen PacketListenFunc
+	mu     deadlock.Mutex // protects following
+	pc     net.PacketConn
+}
+
+// NewPacketBind returns a PacketBind that uses listen to open its net.PacketConn.
+func NewPacketBind(listen PacketListenFunc) *PacketBind {
+	return &PacketBind{listen: listen}
+}
+
+// NewNestedBind returns a PacketBind that carries WireGuard datagrams through
+// the tunnel of parent, using the first of the parent's Config.Addresses.
+// This allows running a WgNet over another WgNet for multi-hop tunnels.
+func NewNestedBind(parent *WgNet) *PacketBind {
+	return NewPacketBind(func(port uint16) (pc net.PacketConn, err error) {
+		err = ErrMissingInterfaceAddress
+		if len(parent.cfg.Addresses) > 0 {
+			addrport := netip.AddrPortFrom(parent.cfg.Addresses[0].Addr(), port)
+			pc, err = parent.ListenPacket("udp", addrport.String())
+		}
+		return
+	})
+}
+
+func (b *PacketBind) getpc() (pc net.PacketConn) {
+	b.mu.Lock()
+	pc = b.pc
+	b.mu.Unlock()
+	return
+}
+
+// Open implements conn.Bind.
+func (b *PacketBind) Open(port uint16) (fns []conn.ReceiveFunc, actualPort uint16, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err = conn.ErrBindAlreadyOpen
+	if b.pc == nil {
+		var pc net.PacketConn
+		if pc, err = b.listen(port); err == nil {
+			b.pc = pc
+			if ua, ok := pc.LocalAddr().(*net.UDPAddr); ok {
+				actualPort = uint16(ua.Port) // #nosec G115
+			}
+			fns = []conn.ReceiveFunc{func(packets [][]byte, sizes []int, eps []conn.Endpoint) (n int, err error) {
+				var addr net.Addr
+				if sizes[0], addr, err = pc.ReadFrom(packets[0]); err == nil {
+					n = 1
+					eps[0] = &packetEndpoint{}
+					if ua, ok := addr.(*net.UDPAddr); ok {
+						eps[0] = &packetEndpoint{ap: ua.AddrPort()}
+					}
+				} else if b.getpc() != pc {
+					err = errors.Join(net.ErrClosed, err)
+				}
+				return
+			}}
+		}
+	}
+	return
+}
+
+// Close implements conn.Bind.
+func (b *PacketBind) Close() (err error) {
+	b.mu.Lock()
+	pc := b.pc
+	b.pc = nil
+	b.mu.Unlock()
+	if pc != nil {
+		err = pc.Close()
+	}
+	return
+}
+
+// SetMark implements conn.Bind and does nothing.
+func (b *PacketBind) SetMark(mark uint32) error {
+	return nil
+}
+
+// Send implements conn.Bind.
+func (b *PacketBind) Send(bufs [][]byte, ep conn.Endpoint) (err error) {
+	pep, ok := ep.(*packetEndpoint)
+	if !ok {
+		return conn.ErrWrongEndpointType
+	}
+	err = net.ErrClosed
+	if pc := b.getpc(); pc != nil {
+		err = nil
+		addr := net.UDPAddrFromAddrPort(pep.ap)
+		for _, buf := range bufs {
+			if _, err = pc.WriteTo(buf, addr); err != nil {
+				break
+			}
+		}
+	}
+	return
+}
+
+// ParseEndpoint implements conn.Bind.
+func (b *PacketBind) ParseEndpoint(s string) (ep conn.Endpoint, err error) {
+	var ap netip.AddrPort
+	if ap, err = netip.ParseAddrPort(s); err == nil {
+		ep = &packetEndpoint{ap: ap}
+	}
+	return
+}
+
+// BatchSize implements conn.Bind.
+func (b *PacketBind) BatchSize() int {
+	return 1
+}
+
+type packetEndpoint struct {
+	ap netip.AddrPort
+}
+
+func (ep *packetEndpoint) ClearSrc()           {}
+func (ep *packetEndpoint) SrcToString() string { return "" }
+func (ep *packetEndpoint) DstToString() string { return ep.ap.String() }
+func (ep *packetEndpoint) DstToBytes() []byte  { b, _ := ep.ap.MarshalBinary(); return b }
+func (ep *packetEndpoint) DstIP() netip.Addr   { return ep.ap.Addr() }
+func (ep *packetEndpoint) SrcIP() netip.Addr   { return netip.Addr{} }
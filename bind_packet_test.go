@@ -0,0 +1,102 @@
+package wgnet_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+var innerServerConfig = `[Interface]
+PrivateKey = GInruesHOogIjjFsKCorYEAENAfYfPL/yH8ObFgyFUs=
+ListenPort = 51821
+Address = 10.131.133.1/24
+
+[Peer]
+PublicKey = kTUQWHx4Y3ZYMZQPnRarzlx0qnen3plDoI0z7s45in4=
+AllowedIPs = 10.131.133.2/32
+`
+
+var innerClientConfig = `[Interface]
+PrivateKey = AEnvL9tVr+7JF0sMVjjzPjIxrrc/hoVJ5B82WWpVamI=
+Address = 10.131.133.2/24
+
+[Peer]
+PublicKey = Wh3yY7/fE3fyHJ8TOwLJ//CIRbgrlVl4bLQ+npNBSRU=
+Endpoint = 10.131.132.1:51821
+AllowedIPs = 10.131.133.0/24
+`
+
+func TestNestedBind(t *testing.T) {
+	outerSrv, outerCli := makeNets()
+	defer func() {
+		maybeFatal(t, outerCli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, outerSrv.Close())
+	}()
+
+	innerSrvCfg, err := wgnet.Parse(strings.NewReader(innerServerConfig), nil)
+	maybeFatal(t, err)
+	innerCliCfg, err := wgnet.Parse(strings.NewReader(innerClientConfig), nil)
+	maybeFatal(t, err)
+
+	innerSrv := wgnet.New(innerSrvCfg)
+	maybeFatal(t, innerSrv.OpenBind(wgnet.NewNestedBind(outerSrv)))
+	defer func() {
+		maybeFatal(t, innerSrv.Close())
+	}()
+	innerCli := wgnet.New(innerCliCfg)
+	maybeFatal(t, innerCli.OpenBind(wgnet.NewNestedBind(outerCli)))
+	defer func() {
+		maybeFatal(t, innerCli.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	latency, err := innerCli.Ping4(ctx, "10.131.133.1")
+	maybeFatal(t, err)
+	t.Log(latency)
+}
+
+func TestWgNet_ListenPacket(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	pc, err := srv.ListenPacket("udp", "10.131.132.1:0")
+	maybeFatal(t, err)
+	defer func() {
+		maybeFatal(t, pc.Close())
+	}()
+
+	conn, err := cli.Dial("udp", pc.LocalAddr().String())
+	maybeFatal(t, err)
+	defer func() {
+		maybeFatal(t, conn.Close())
+	}()
+	_, err = conn.Write([]byte("hello"))
+	maybeFatal(t, err)
+
+	maybeFatal(t, pc.SetReadDeadline(time.Now().Add(time.Second*5)))
+	buf := make([]byte, 16)
+	n, _, err := pc.ReadFrom(buf)
+	maybeFatal(t, err)
+	if got := string(buf[:n]); got != "hello" {
+		t.Error(got)
+	}
+
+	if _, err = srv.ListenPacket("tcp", "10.131.132.1:0"); !errors.Is(err, wgnet.ErrUnsupportedNetwork) {
+		t.Error(err)
+	}
+	if _, err = wgnet.NewPacketBind(nil).ParseEndpoint("bad"); err == nil {
+		t.Error("expected error")
+	}
+}
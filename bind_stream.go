@@ -0,0 +1,274 @@
+package wgnet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/linkdata/deadlock"
+	"golang.org/x/net/websocket"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// DialFunc dials a stream connection to address.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+var ErrPacketTooLarge = errors.New("packet too large")
+
+const streamDialTimeout = time.Second * 10
+
+// StreamBind is a conn.Bind that carries WireGuard datagrams over stream
+// connections such as TCP or WebSocket, for networks that block UDP.
+// Each datagram is framed with a 16-bit big-endian length prefix.
+//
+// Outgoing connections are made using the DialFunc given to NewStreamBind.
+// Incoming connections are accepted using Serve or WebSocketHandler.
+type StreamBind struct {
+	dial  DialFunc
+	mu    deadlock.Mutex // protects following
+	conns map[string]*streamConn
+	recv  chan streamPacket
+	done  chan struct{}
+}
+
+type streamPacket struct {
+	data []byte
+	ep   *streamEndpoint
+}
+
+type streamConn struct {
+	net.Conn
+	ep   *streamEndpoint
+	wmu  deadlock.Mutex
+	done chan struct{}
+}
+
+// NewStreamBind returns a StreamBind that dials outgoing connections using dial.
+// If dial is nil, the bind only accepts incoming connections.
+func NewStreamBind(dial DialFunc) *StreamBind {
+	return &StreamBind{dial: dial, conns: make(map[string]*streamConn)}
+}
+
+// NewTCPBind returns a StreamBind that dials peer endpoints using TCP.
+func NewTCPBind() *StreamBind {
+	return NewStreamBind((&net.Dialer{}).DialContext)
+}
+
+// WebSocketDialer returns a DialFunc that connects to the WebSocket server at
+// rawurl, ignoring the peer endpoint address.
+func WebSocketDialer(rawurl, origin string) DialFunc {
+	return func(ctx context.Context, network, address string) (c net.Conn, err error) {
+		var cfg *websocket.Config
+		if cfg, err = websocket.NewConfig(rawurl, origin); err == nil {
+			var ws *websocket.Conn
+			if ws, err = cfg.DialContext(ctx); err == nil {
+				ws.PayloadType = websocket.BinaryFrame
+				c = ws
+			}
+		}
+		return
+	}
+}
+
+// Serve accepts connections from l and attaches them to the bind.
+// It returns when l.Accept fails, typically because l was closed.
+func (b *StreamBind) Serve(l net.Listener) (err error) {
+	for {
+		var c net.Conn
+		if c, err = l.Accept(); err != nil {
+			return
+		}
+		_, _ = b.attach(c, newStreamEndpoint(c.RemoteAddr().String()))
+	}
+}
+
+// WebSocketHandler returns a http.Handler that attaches incoming WebSocket
+// connections to the bind.
+func (b *StreamBind) WebSocketHandler() http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		if sc, err := b.attach(ws, newStreamEndpoint(ws.Request().RemoteAddr)); err == nil {
+			<-sc.done
+		}
+	})
+}
+
+func (b *StreamBind) attach(c net.Conn, ep *streamEndpoint) (sc *streamConn, err error) {
+	sc = &streamConn{Conn: c, ep: ep, done: make(chan struct{})}
+	b.mu.Lock()
+	recv, done := b.recv, b.done
+	err = net.ErrClosed
+	if recv != nil {
+		err = nil
+		if old := b.conns[ep.dst]; old != nil {
+			_ = old.Close()
+		}
+		b.conns[ep.dst] = sc
+	}
+	b.mu.Unlock()
+	if err == nil {
+		go b.reader(sc, recv, done)
+	} else {
+		_ = c.Close()
+		close(sc.done)
+	}
+	return
+}
+
+func (b *StreamBind) detach(sc *streamConn) {
+	b.mu.Lock()
+	if b.conns[sc.ep.dst] == sc {
+		delete(b.conns, sc.ep.dst)
+	}
+	b.mu.Unlock()
+	_ = sc.Close()
+}
+
+func (b *StreamBind) reader(sc *streamConn, recv chan<- streamPacket, done <-chan struct{}) {
+	defer close(sc.done)
+	defer b.detach(sc)
+	var hdr [2]byte
+	for {
+		if _, err := io.ReadFull(sc, hdr[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(sc, data); err != nil {
+			return
+		}
+		select {
+		case recv <- streamPacket{data: data, ep: sc.ep}:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (b *StreamBind) getconn(ep *streamEndpoint) (sc *streamConn, err error) {
+	b.mu.Lock()
+	sc = b.conns[ep.dst]
+	recv := b.recv
+	b.mu.Unlock()
+	if sc == nil {
+		err = net.ErrClosed
+		if recv != nil && b.dial != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), streamDialTimeout)
+			defer cancel()
+			var c net.Conn
+			if c, err = b.dial(ctx, "tcp", ep.dst); err == nil {
+				sc, err = b.attach(c, ep)
+			}
+		}
+	}
+	return
+}
+
+// Open implements conn.Bind. The port is ignored.
+func (b *StreamBind) Open(port uint16) (fns []conn.ReceiveFunc, actualPort uint16, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err = conn.ErrBindAlreadyOpen
+	if b.recv == nil {
+		err = nil
+		recv := make(chan streamPacket, 64)
+		done := make(chan struct{})
+		b.recv, b.done = recv, done
+		fns = []conn.ReceiveFunc{func(packets [][]byte, sizes []int, eps []conn.Endpoint) (n int, err error) {
+			select {
+			case pkt := <-recv:
+				sizes[0] = copy(packets[0], pkt.data)
+				eps[0] = pkt.ep
+				n = 1
+			case <-done:
+				err = net.ErrClosed
+			}
+			return
+		}}
+		actualPort = port
+	}
+	return
+}
+
+// Close implements conn.Bind, closing all connections.
+func (b *StreamBind) Close() (err error) {
+	b.mu.Lock()
+	conns := b.conns
+	if b.done != nil {
+		close(b.done)
+	}
+	b.recv, b.done = nil, nil
+	b.conns = make(map[string]*streamConn)
+	b.mu.Unlock()
+	for _, sc := range conns {
+		_ = sc.Close()
+	}
+	return
+}
+
+// SetMark implements conn.Bind and does nothing.
+func (b *StreamBind) SetMark(mark uint32) error {
+	return nil
+}
+
+// Send implements conn.Bind.
+func (b *StreamBind) Send(bufs [][]byte, ep conn.Endpoint) (err error) {
+	sep, ok := ep.(*streamEndpoint)
+	if !ok {
+		return conn.ErrWrongEndpointType
+	}
+	var sc *streamConn
+	if sc, err = b.getconn(sep); err == nil {
+		sc.wmu.Lock()
+		defer sc.wmu.Unlock()
+		for _, buf := range bufs {
+			if len(buf) > 0xFFFF {
+				return ErrPacketTooLarge
+			}
+			frame := make([]byte, 2+len(buf))
+			binary.BigEndian.PutUint16(frame, uint16(len(buf))) // #nosec G115
+			copy(frame[2:], buf)
+			if _, err = sc.Write(frame); err != nil {
+				b.detach(sc)
+				return
+			}
+		}
+	}
+	return
+}
+
+// ParseEndpoint implements conn.Bind.
+func (b *StreamBind) ParseEndpoint(s string) (ep conn.Endpoint, err error) {
+	var ap netip.AddrPort
+	if ap, err = netip.ParseAddrPort(s); err == nil {
+		ep = &streamEndpoint{dst: ap.String(), ap: ap}
+	}
+	return
+}
+
+// BatchSize implements conn.Bind.
+func (b *StreamBind) BatchSize() int {
+	return 1
+}
+
+type streamEndpoint struct {
+	dst string
+	ap  netip.AddrPort
+}
+
+func newStreamEndpoint(dst string) (ep *streamEndpoint) {
+	ep = &streamEndpoint{dst: dst}
+	ep.ap, _ = netip.ParseAddrPort(dst)
+	return
+}
+
+func (ep *streamEndpoint) ClearSrc()           {}
+func (ep *streamEndpoint) SrcToString() string { return "" }
+func (ep *streamEndpoint) DstToString() string { return ep.dst }
+func (ep *streamEndpoint) DstToBytes() []byte  { return []byte(ep.dst) }
+func (ep *streamEndpoint) DstIP() netip.Addr   { return ep.ap.Addr() }
+func (ep *streamEndpoint) SrcIP() netip.Addr   { return netip.Addr{} }
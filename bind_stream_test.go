@@ -0,0 +1,89 @@
+package wgnet_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+func makeBoundNets(t *testing.T, srvBind, cliBind conn.Bind, endpointPort int) (srv, cli *wgnet.WgNet) {
+	t.Helper()
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, 0)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, endpointPort)), nil)
+	maybeFatal(t, err)
+	srv = wgnet.New(srvCfg)
+	maybeFatal(t, srv.OpenBind(srvBind))
+	cli = wgnet.New(cliCfg)
+	if err = cli.OpenBind(cliBind); err != nil {
+		_ = srv.Close()
+		t.Fatal(err)
+	}
+	return
+}
+
+func pingServer(t *testing.T, cli *wgnet.WgNet) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	latency, err := cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	t.Log(latency)
+}
+
+func TestStreamBind_TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	maybeFatal(t, err)
+	defer l.Close()
+
+	srvBind := wgnet.NewStreamBind(nil)
+	go func() { _ = srvBind.Serve(l) }()
+
+	srv, cli := makeBoundNets(t, srvBind, wgnet.NewTCPBind(), l.Addr().(*net.TCPAddr).Port)
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	pingServer(t, cli)
+}
+
+func TestStreamBind_WebSocket(t *testing.T) {
+	srvBind := wgnet.NewStreamBind(nil)
+	hs := httptest.NewServer(srvBind.WebSocketHandler())
+	defer hs.Close()
+
+	wsurl := "ws" + strings.TrimPrefix(hs.URL, "http")
+	cliBind := wgnet.NewStreamBind(wgnet.WebSocketDialer(wsurl, hs.URL))
+
+	srv, cli := makeBoundNets(t, srvBind, cliBind, hs.Listener.Addr().(*net.TCPAddr).Port)
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	pingServer(t, cli)
+}
+
+func TestStreamBind_ClosedSendFails(t *testing.T) {
+	b := wgnet.NewTCPBind()
+	ep, err := b.ParseEndpoint("127.0.0.1:1")
+	maybeFatal(t, err)
+	if err = b.Send([][]byte{{1}}, ep); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = b.ParseEndpoint("localhost"); err == nil {
+		t.Fatal("expected error")
+	}
+}
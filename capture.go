@@ -0,0 +1,141 @@
+package wgnet
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/linkdata/deadlock"
+)
+
+// CaptureFilter selects the packets written by StartCapture.
+// Empty fields match all packets.
+type CaptureFilter struct {
+	Prefixes []netip.Prefix // the source or destination address is in one of these
+	Ports    []uint16       // the TCP or UDP source or destination port is one of these
+}
+
+func (f *CaptureFilter) match(pi packetInfo) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Prefixes) > 0 && !slices.ContainsFunc(f.Prefixes, func(pf netip.Prefix) bool {
+		return pf.Contains(pi.Src) || pf.Contains(pi.Dst)
+	}) {
+		return false
+	}
+	if len(f.Ports) > 0 && (pi.SPort == 0 || !(slices.Contains(f.Ports, pi.SPort) || slices.Contains(f.Ports, pi.DPort))) {
+		return false
+	}
+	return true
+}
+
+// pcapng block types, link type and option codes.
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterface      = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngLinkTypeRaw    = 101
+	pcapngOptEndOfOpt    = 0
+	pcapngOptEPBFlags    = 2
+	pcapngFlagInbound    = 1
+	pcapngFlagOutbound   = 2
+	pcapngInterfaceLen   = 20
+)
+
+// capturer writes packets to a pcapng stream.
+type capturer struct {
+	filter *CaptureFilter
+	mu     deadlock.Mutex // protects following
+	w      io.Writer
+	err    error // first write error, after which nothing more is written
+}
+
+func newCapturer(w io.Writer, filter *CaptureFilter) (c *capturer, err error) {
+	c = &capturer{filter: filter, w: w}
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint32(buf, pcapngSectionHeader)
+	buf = binary.LittleEndian.AppendUint32(buf, 28)
+	buf = binary.LittleEndian.AppendUint32(buf, pcapngByteOrderMagic)
+	buf = binary.LittleEndian.AppendUint16(buf, 1) // major version
+	buf = binary.LittleEndian.AppendUint16(buf, 0) // minor version
+	buf = binary.LittleEndian.AppendUint64(buf, ^uint64(0))
+	buf = binary.LittleEndian.AppendUint32(buf, 28)
+	buf = binary.LittleEndian.AppendUint32(buf, pcapngInterface)
+	buf = binary.LittleEndian.AppendUint32(buf, pcapngInterfaceLen)
+	buf = binary.LittleEndian.AppendUint16(buf, pcapngLinkTypeRaw)
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, 0) // no snap length limit
+	buf = binary.LittleEndian.AppendUint32(buf, pcapngInterfaceLen)
+	_, err = w.Write(buf)
+	return
+}
+
+// packet writes pkt as an enhanced packet block if it matches the filter.
+func (c *capturer) packet(pkt []byte, inbound bool) {
+	if pi, ok := parsePacket(pkt); ok && c.filter.match(pi) {
+		flags := uint32(pcapngFlagOutbound)
+		if inbound {
+			flags = pcapngFlagInbound
+		}
+		padded := (len(pkt) + 3) &^ 3
+		total := 28 + padded + 12 + 4
+		ts := uint64(time.Now().UnixMicro()) // #nosec G115
+		buf := make([]byte, 0, total)
+		buf = binary.LittleEndian.AppendUint32(buf, pcapngEnhancedPacket)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(total)) // #nosec G115
+		buf = binary.LittleEndian.AppendUint32(buf, 0)             // interface ID
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(ts>>32))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(ts))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(pkt))) // #nosec G115
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(pkt))) // #nosec G115
+		buf = append(buf, pkt...)
+		buf = append(buf, make([]byte, padded-len(pkt))...)
+		buf = binary.LittleEndian.AppendUint16(buf, pcapngOptEPBFlags)
+		buf = binary.LittleEndian.AppendUint16(buf, 4)
+		buf = binary.LittleEndian.AppendUint32(buf, flags)
+		buf = binary.LittleEndian.AppendUint32(buf, pcapngOptEndOfOpt)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(total)) // #nosec G115
+		c.mu.Lock()
+		if c.err == nil {
+			_, c.err = c.w.Write(buf)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// StartCapture writes every IP packet passing between the netstack and the
+// WireGuard device to w in pcapng format, with raw IP link type, until
+// StopCapture is called. Only packets matching filter are written, and a
+// nil filter matches all. Starting a capture stops any previous one, and a
+// capture continues across Close and Open. Writes to w are serialized but
+// happen on the data path, so w should be fast, such as a buffered file.
+func (wgnet *WgNet) StartCapture(w io.Writer, filter *CaptureFilter) (err error) {
+	var c *capturer
+	if c, err = newCapturer(w, filter); err == nil {
+		if prev := wgnet.capture.Swap(c); prev != nil {
+			_ = prev.stop()
+		}
+	}
+	return
+}
+
+// StopCapture stops the capture started by StartCapture, returning the
+// first error writing to its io.Writer, if any.
+func (wgnet *WgNet) StopCapture() (err error) {
+	if c := wgnet.capture.Swap(nil); c != nil {
+		err = c.stop()
+	}
+	return
+}
+
+func (c *capturer) stop() (err error) {
+	c.mu.Lock()
+	err = c.err
+	c.err = io.ErrClosedPipe
+	c.mu.Unlock()
+	return
+}
@@ -0,0 +1,98 @@
+package wgnet_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+type capturedPacket struct {
+	data    []byte
+	inbound bool
+}
+
+// readPcapng returns the packets in a pcapng stream written by StartCapture.
+func readPcapng(t *testing.T, b []byte) (pkts []capturedPacket) {
+	t.Helper()
+	if len(b) < 48 || binary.LittleEndian.Uint32(b) != 0x0A0D0D0A || binary.LittleEndian.Uint32(b[8:]) != 0x1A2B3C4D {
+		t.Fatalf("bad section header % x", b[:min(len(b), 28)])
+	}
+	if binary.LittleEndian.Uint32(b[28:]) != 1 || binary.LittleEndian.Uint16(b[36:]) != 101 {
+		t.Fatalf("bad interface description % x", b[28:48])
+	}
+	for b = b[48:]; len(b) >= 12; {
+		total := int(binary.LittleEndian.Uint32(b[4:]))
+		if total < 12 || total > len(b) || binary.LittleEndian.Uint32(b[total-4:]) != uint32(total) {
+			t.Fatalf("bad block length %d", total)
+		}
+		if binary.LittleEndian.Uint32(b) == 6 {
+			caplen := int(binary.LittleEndian.Uint32(b[20:]))
+			flags := binary.LittleEndian.Uint32(b[28+(caplen+3)&^3+4:])
+			pkts = append(pkts, capturedPacket{data: b[28 : 28+caplen], inbound: flags == 1})
+		}
+		b = b[total:]
+	}
+	if len(b) != 0 {
+		t.Fatalf("%d trailing bytes", len(b))
+	}
+	return
+}
+
+func TestWgNet_Capture(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+	l, err := srv.Listen("tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	defer l.Close()
+
+	var all, web bytes.Buffer
+	maybeFatal(t, cli.StartCapture(&all, nil))
+	maybeFatal(t, srv.StartCapture(&web, &wgnet.CaptureFilter{Ports: []uint16{80}}))
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	_, err = cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	c, err := cli.DialContext(ctx, "tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	maybeFatal(t, c.Close())
+
+	maybeFatal(t, cli.StopCapture())
+	maybeFatal(t, srv.StopCapture())
+	n := all.Len()
+	_, err = cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	if all.Len() != n {
+		t.Error("capture continued after StopCapture")
+	}
+
+	var echoRequest, echoReply bool
+	for _, p := range readPcapng(t, all.Bytes()) {
+		if p.data[9] == 1 { // ICMP
+			echoRequest = echoRequest || (!p.inbound && p.data[20] == 8)
+			echoReply = echoReply || (p.inbound && p.data[20] == 0)
+		}
+	}
+	if !echoRequest || !echoReply {
+		t.Error("ping not captured", echoRequest, echoReply)
+	}
+
+	pkts := readPcapng(t, web.Bytes())
+	if len(pkts) == 0 {
+		t.Error("no packets captured for port 80")
+	}
+	for _, p := range pkts {
+		if p.data[9] != 6 {
+			t.Errorf("captured protocol %d", p.data[9])
+		}
+	}
+}
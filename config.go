@@ -3,7 +3,11 @@ package wgnet
 import (
 	"encoding/base64"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net/netip"
+	"slices"
 	"strings"
 )
 
@@ -13,11 +17,16 @@ type Config struct {
 	PublicKey           []byte // #nosec G117
 	PresharedKey        []byte // #nosec G117
 	Endpoint            netip.AddrPort
+	FallbackEndpoints   []netip.AddrPort // tried in order when Endpoint stops responding
 	AllowedIPs          []netip.Prefix
 	DNS                 []netip.Addr
+	SecureDNS           []string                // DNS-over-HTTPS (https://) and DNS-over-TLS (tls://) server URLs
+	SearchDomains       []string                // non-IP [Interface] DNS entries, applied to relative names
+	Hosts               map[string][]netip.Addr // static names from [Hosts], consulted before DNS
 	ListenPort          int
 	LogLevel            int
 	PersistentKeepalive int
+	dropped             []netip.Prefix // addresses dropped by Parse
 }
 
 func (cfg *Config) UapiConf() string {
@@ -42,9 +51,52 @@ func (cfg *Config) UapiConf() string {
 	return buf.String()
 }
 
-func (cfg *Config) String() string {
+// Redacted is written in place of secret keys by String.
+const Redacted = "(hidden)"
+
+// String returns the WireGuard INI file content with the private and
+// preshared keys replaced by Redacted, making it safe to log.
+// Use Marshal to get the real file content. It has a value receiver so
+// that Config values and structs containing them are redacted as well.
+func (cfg Config) String() string {
+	return cfg.marshal(true)
+}
+
+// Format implements fmt.Formatter so that all verbs print the redacted
+// form returned by String.
+func (cfg Config) Format(f fmt.State, verb rune) {
+	formatRedacted(f, verb, cfg.String())
+}
+
+// LogValue implements slog.LogValuer so that slog handlers log the
+// redacted form returned by String rather than marshaling the secret keys.
+func (cfg Config) LogValue() slog.Value {
+	return slog.StringValue(cfg.String())
+}
+
+func formatRedacted(f fmt.State, verb rune, s string) {
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", s)
+	} else {
+		_, _ = io.WriteString(f, s)
+	}
+}
+
+// Marshal returns the WireGuard INI file content, including secret keys.
+func (cfg *Config) Marshal() string {
+	return cfg.marshal(false)
+}
+
+func secretKey(key []byte, redact bool) string {
+	if redact {
+		return Redacted
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func (cfg *Config) marshal(redact bool) string {
 	var buf strings.Builder
-	fmt.Fprintf(&buf, "[Interface]\nPrivateKey = %s", base64.StdEncoding.EncodeToString(cfg.PrivateKey))
+	fmt.Fprintf(&buf, "[Interface]\nPrivateKey = %s", secretKey(cfg.PrivateKey, redact))
 	if cfg.ListenPort > 0 {
 		fmt.Fprintf(&buf, "\nListenPort = %d", cfg.ListenPort)
 	}
@@ -57,7 +109,7 @@ func (cfg *Config) String() string {
 			buf.WriteString(pf.String())
 		}
 	}
-	if len(cfg.DNS) > 0 {
+	if len(cfg.DNS)+len(cfg.SecureDNS)+len(cfg.SearchDomains) > 0 {
 		buf.WriteString("\nDNS = ")
 		for n, addr := range cfg.DNS {
 			if n > 0 {
@@ -65,15 +117,31 @@ func (cfg *Config) String() string {
 			}
 			buf.WriteString(addr.String())
 		}
+		for n, server := range cfg.SecureDNS {
+			if n+len(cfg.DNS) > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(server)
+		}
+		for n, domain := range cfg.SearchDomains {
+			if n+len(cfg.DNS)+len(cfg.SecureDNS) > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(domain)
+		}
 	}
 	fmt.Fprintf(&buf, "\n\n[Peer]\nPublicKey = %s",
 		base64.StdEncoding.EncodeToString(cfg.PublicKey),
 	)
 	if cfg.Endpoint.IsValid() {
 		fmt.Fprintf(&buf, "\nEndpoint = %s", cfg.Endpoint.String())
+		for _, ap := range cfg.FallbackEndpoints {
+			buf.WriteByte(',')
+			buf.WriteString(ap.String())
+		}
 	}
 	if len(cfg.PresharedKey) > 0 {
-		fmt.Fprintf(&buf, "\nPresharedKey = %s", base64.StdEncoding.EncodeToString(cfg.PresharedKey))
+		fmt.Fprintf(&buf, "\nPresharedKey = %s", secretKey(cfg.PresharedKey, redact))
 	}
 	if cfg.PersistentKeepalive > 0 {
 		fmt.Fprintf(&buf, "\nPersistentKeepalive = %v", cfg.PersistentKeepalive)
@@ -87,6 +155,25 @@ func (cfg *Config) String() string {
 			buf.WriteString(pf.String())
 		}
 	}
+	if len(cfg.Hosts) > 0 {
+		buf.WriteString("\n\n[Hosts]")
+		for _, name := range slices.Sorted(maps.Keys(cfg.Hosts)) {
+			fmt.Fprintf(&buf, "\n%s = %s", name, joinAddrs(cfg.Hosts[name]))
+		}
+	}
 	buf.WriteByte('\n')
 	return buf.String()
 }
+
+// lookupHosts returns the addresses for name from Hosts.
+func (cfg *Config) lookupHosts(name string) (addrs []string) {
+	for _, addr := range cfg.Hosts[hostsKey(name)] {
+		addrs = append(addrs, addr.String())
+	}
+	return
+}
+
+// hostsKey returns name normalized for use as a key in Config.Hosts.
+func hostsKey(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
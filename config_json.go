@@ -0,0 +1,184 @@
+package wgnet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// configJSON is the JSON and YAML representation of a Config.
+// Keys are base64 encoded and addresses are CIDR or IP strings.
+type configJSON struct {
+	Addresses           []string            `json:"addresses" yaml:"addresses"`
+	PrivateKey          string              `json:"private_key" yaml:"private_key"` // #nosec G117
+	PublicKey           string              `json:"public_key" yaml:"public_key"`
+	PresharedKey        string              `json:"preshared_key,omitempty" yaml:"preshared_key,omitempty"` // #nosec G117
+	Endpoint            string              `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	FallbackEndpoints   []string            `json:"fallback_endpoints,omitempty" yaml:"fallback_endpoints,omitempty"`
+	AllowedIPs          []string            `json:"allowed_ips,omitempty" yaml:"allowed_ips,omitempty"`
+	DNS                 []string            `json:"dns,omitempty" yaml:"dns,omitempty"`
+	SecureDNS           []string            `json:"secure_dns,omitempty" yaml:"secure_dns,omitempty"`
+	SearchDomains       []string            `json:"search_domains,omitempty" yaml:"search_domains,omitempty"`
+	Hosts               map[string][]string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	ListenPort          int                 `json:"listen_port,omitempty" yaml:"listen_port,omitempty"`
+	LogLevel            int                 `json:"log_level,omitempty" yaml:"log_level,omitempty"`
+	PersistentKeepalive int                 `json:"persistent_keepalive,omitempty" yaml:"persistent_keepalive,omitempty"`
+}
+
+func (cfg *Config) toJSON() (cj configJSON) {
+	cj.PrivateKey = base64.StdEncoding.EncodeToString(cfg.PrivateKey)
+	cj.PublicKey = base64.StdEncoding.EncodeToString(cfg.PublicKey)
+	if len(cfg.PresharedKey) > 0 {
+		cj.PresharedKey = base64.StdEncoding.EncodeToString(cfg.PresharedKey)
+	}
+	if cfg.Endpoint.IsValid() {
+		cj.Endpoint = cfg.Endpoint.String()
+	}
+	for _, ap := range cfg.FallbackEndpoints {
+		cj.FallbackEndpoints = append(cj.FallbackEndpoints, ap.String())
+	}
+	for _, pf := range cfg.Addresses {
+		cj.Addresses = append(cj.Addresses, pf.String())
+	}
+	for _, pf := range cfg.AllowedIPs {
+		cj.AllowedIPs = append(cj.AllowedIPs, pf.String())
+	}
+	for _, addr := range cfg.DNS {
+		cj.DNS = append(cj.DNS, addr.String())
+	}
+	cj.SecureDNS = slices.Clone(cfg.SecureDNS)
+	cj.SearchDomains = slices.Clone(cfg.SearchDomains)
+	for name, addrs := range cfg.Hosts {
+		if cj.Hosts == nil {
+			cj.Hosts = make(map[string][]string)
+		}
+		for _, addr := range addrs {
+			cj.Hosts[name] = append(cj.Hosts[name], addr.String())
+		}
+	}
+	cj.ListenPort = cfg.ListenPort
+	cj.LogLevel = cfg.LogLevel
+	cj.PersistentKeepalive = cfg.PersistentKeepalive
+	return
+}
+
+// fromJSON validates cj the same way Parse validates INI files.
+func (cj *configJSON) fromJSON() (cfg *Config, err error) {
+	var cf Config
+	if cf.PrivateKey, err = decodeKey(cj.PrivateKey); err != nil {
+		return nil, errors.Join(ErrInvalidInterfacePrivateKey, err)
+	}
+	if cf.PublicKey, err = decodeKey(cj.PublicKey); err != nil {
+		return nil, errors.Join(ErrInvalidPeerPublicKey, err)
+	}
+	for _, addr := range cj.Addresses {
+		var pf netip.Prefix
+		if pf, err = mustPrefix(addr, ErrInvalidInterfaceAddress); err != nil {
+			return
+		}
+		cf.Addresses = append(cf.Addresses, pf)
+	}
+	if len(cf.Addresses) == 0 {
+		return nil, ErrMissingInterfaceAddress
+	}
+	for _, addr := range cj.DNS {
+		var a netip.Addr
+		if a, err = mustAddress(addr, ErrInvalidInterfaceDNS); err != nil {
+			return
+		}
+		cf.DNS = append(cf.DNS, a)
+	}
+	for _, server := range cj.SecureDNS {
+		if !isSecureDNS(server) {
+			return nil, ErrInvalidInterfaceDNS
+		}
+		cf.SecureDNS = append(cf.SecureDNS, server)
+	}
+	for _, domain := range cj.SearchDomains {
+		if !isSearchDomain(domain) {
+			return nil, ErrInvalidInterfaceDNS
+		}
+		cf.SearchDomains = append(cf.SearchDomains, strings.TrimSuffix(domain, "."))
+	}
+	hosts := make(map[string]string)
+	for name, addrs := range cj.Hosts {
+		hosts[name] = strings.Join(addrs, ",")
+	}
+	if cf.Hosts, err = parseHosts(hosts, nil); err != nil {
+		return
+	}
+	for _, addr := range cj.AllowedIPs {
+		var pf netip.Prefix
+		if pf, err = mustPrefix(addr, ErrInvalidPeerAllowedIPs); err != nil {
+			return
+		}
+		cf.AllowedIPs = append(cf.AllowedIPs, pf)
+	}
+	if cj.PresharedKey != "" {
+		if cf.PresharedKey, err = decodePresharedKey(cj.PresharedKey); err != nil {
+			return nil, errors.Join(ErrInvalidPeerPresharedKey, err)
+		}
+	}
+	if cj.PersistentKeepalive < 0 || cj.PersistentKeepalive > 0xFFFF {
+		return nil, ErrInvalidPeerPersistentKeepalive
+	}
+	if cj.ListenPort < 0 || cj.ListenPort > 0xFFFF {
+		return nil, ErrInvalidInterfaceListenPort
+	}
+	if cj.Endpoint != "" {
+		if cf.Endpoint, err = netip.ParseAddrPort(cj.Endpoint); err != nil {
+			return nil, errors.Join(ErrInvalidPeerEndpoint, err)
+		}
+	}
+	for _, ep := range cj.FallbackEndpoints {
+		var ap netip.AddrPort
+		if ap, err = netip.ParseAddrPort(ep); err != nil {
+			return nil, errors.Join(ErrInvalidPeerEndpoint, err)
+		}
+		cf.FallbackEndpoints = append(cf.FallbackEndpoints, ap)
+	}
+	cf.ListenPort = cj.ListenPort
+	cf.LogLevel = cj.LogLevel
+	cf.PersistentKeepalive = cj.PersistentKeepalive
+	return &cf, nil
+}
+
+// MarshalJSON implements json.Marshaler, including secret keys. Loggers
+// using slog get the redacted form from LogValue instead.
+func (cfg *Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cfg.toJSON())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, validating the result the
+// same way Parse does.
+func (cfg *Config) UnmarshalJSON(b []byte) (err error) {
+	var cj configJSON
+	if err = json.Unmarshal(b, &cj); err == nil {
+		var cf *Config
+		if cf, err = cj.fromJSON(); err == nil {
+			*cfg = *cf
+		}
+	}
+	return
+}
+
+// MarshalYAML implements the Marshaler interface of the common YAML packages.
+func (cfg *Config) MarshalYAML() (any, error) {
+	return cfg.toJSON(), nil
+}
+
+// UnmarshalYAML implements the Unmarshaler interface of the common YAML
+// packages, validating the result the same way Parse does.
+func (cfg *Config) UnmarshalYAML(unmarshal func(any) error) (err error) {
+	var cj configJSON
+	if err = unmarshal(&cj); err == nil {
+		var cf *Config
+		if cf, err = cj.fromJSON(); err == nil {
+			*cfg = *cf
+		}
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package wgnet_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linkdata/wgnet"
+)
+
+func TestConfig_JSON_RoundTrip(t *testing.T) {
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	cfg.SearchDomains = []string{"corp.internal"}
+	cfg.Hosts = map[string][]netip.Addr{"db.corp.internal": {netip.MustParseAddr("10.0.0.5")}}
+	b, err := json.Marshal(cfg)
+	maybeFatal(t, err)
+	if !strings.Contains(string(b), `"hosts":{"db.corp.internal":["10.0.0.5"]}`) {
+		t.Error(string(b))
+	}
+	if !strings.Contains(string(b), `"search_domains":["corp.internal"]`) {
+		t.Error(string(b))
+	}
+	if !strings.Contains(string(b), `"addresses":["192.168.1.0/24","10.0.0.0/8"]`) {
+		t.Error(string(b))
+	}
+	if !strings.Contains(string(b), `"preshared_key":"AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="`) {
+		t.Error(string(b))
+	}
+	var got wgnet.Config
+	maybeFatal(t, json.Unmarshal(b, &got))
+	if !reflect.DeepEqual(&got, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", &got, cfg)
+	}
+}
+
+func TestConfig_JSON_Validates(t *testing.T) {
+	const valid = `"private_key":"WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=","public_key":"WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E="`
+	tests := []struct {
+		name    string
+		text    string
+		wantErr error
+	}{
+		{"privatekey", `{"private_key":"meh"}`, wgnet.ErrInvalidInterfacePrivateKey},
+		{"publickey", `{"private_key":"WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=","public_key":"Zm9vYmFy"}`, wgnet.ErrKeyLengthNot32Bytes},
+		{"noaddress", `{` + valid + `}`, wgnet.ErrMissingInterfaceAddress},
+		{"address", `{` + valid + `,"addresses":["meh"]}`, wgnet.ErrInvalidInterfaceAddress},
+		{"dns", `{` + valid + `,"addresses":["10.0.0.1/24"],"dns":["meh"]}`, wgnet.ErrInvalidInterfaceDNS},
+		{"secure_dns", `{` + valid + `,"addresses":["10.0.0.1/24"],"secure_dns":["http://10.0.0.1"]}`, wgnet.ErrInvalidInterfaceDNS},
+		{"search_domains", `{` + valid + `,"addresses":["10.0.0.1/24"],"search_domains":["corp internal"]}`, wgnet.ErrInvalidInterfaceDNS},
+		{"allowedips", `{` + valid + `,"addresses":["10.0.0.1/24"],"allowed_ips":["meh"]}`, wgnet.ErrInvalidPeerAllowedIPs},
+		{"presharedkey", `{` + valid + `,"addresses":["10.0.0.1/24"],"preshared_key":"meh"}`, wgnet.ErrInvalidPeerPresharedKey},
+		{"keepalive", `{` + valid + `,"addresses":["10.0.0.1/24"],"persistent_keepalive":70000}`, wgnet.ErrInvalidPeerPersistentKeepalive},
+		{"listenport", `{` + valid + `,"addresses":["10.0.0.1/24"],"listen_port":-1}`, wgnet.ErrInvalidInterfaceListenPort},
+		{"endpoint", `{` + valid + `,"addresses":["10.0.0.1/24"],"endpoint":"localhost:1"}`, wgnet.ErrInvalidPeerEndpoint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg wgnet.Config
+			if err := json.Unmarshal([]byte(tt.text), &cfg); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// yamlFields returns the fields of the struct v keyed by their yaml tag,
+// omitting empty ones, as a YAML encoder would.
+func yamlFields(v reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	for i := range v.NumField() {
+		name, opts, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if f := v.Field(i); name != "" && !(opts == "omitempty" && f.IsZero()) {
+			fields[name] = f
+		}
+	}
+	return fields
+}
+
+// fakeYAML returns an unmarshal function for UnmarshalYAML that decodes the
+// value returned by MarshalYAML using only its yaml struct tags.
+func fakeYAML(t *testing.T, marshalled any) func(any) error {
+	t.Helper()
+	fields := yamlFields(reflect.Indirect(reflect.ValueOf(marshalled)))
+	if len(fields) == 0 {
+		t.Fatal("no yaml tagged fields")
+	}
+	return func(target any) error {
+		v := reflect.ValueOf(target).Elem()
+		for i := range v.NumField() {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if src, ok := fields[name]; ok {
+				v.Field(i).Set(src)
+			}
+		}
+		return nil
+	}
+}
+
+func TestConfig_YAML_RoundTrip(t *testing.T) {
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	v, err := cfg.MarshalYAML()
+	maybeFatal(t, err)
+	var got wgnet.Config
+	maybeFatal(t, got.UnmarshalYAML(fakeYAML(t, v)))
+	if !reflect.DeepEqual(&got, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", &got, cfg)
+	}
+}
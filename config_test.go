@@ -1,6 +1,9 @@
 package wgnet_test
 
 import (
+	"bytes"
+	"fmt"
+	"log/slog"
 	"strings"
 	"testing"
 
@@ -21,18 +24,18 @@ PersistentKeepalive = 10
 AllowedIPs = 192.168.1.0/24,10.0.0.0/8
 `
 
-func TestConfig_String(t *testing.T) {
+func TestConfig_Marshal(t *testing.T) {
 	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := cfg.String()
+	got := cfg.Marshal()
 	if got != text {
 		t.Errorf("mismatch\n got: %s\nwant: %s\n", got, text)
 	}
 }
 
-func TestConfig_String_Base64PresharedKey(t *testing.T) {
+func TestConfig_Marshal_Base64PresharedKey(t *testing.T) {
 	const base64PSK = "WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E="
 	const input = `[Interface]
 PrivateKey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
@@ -49,9 +52,51 @@ AllowedIPs = 0.0.0.0/0
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := cfg.String()
+	got := cfg.Marshal()
 	if !strings.Contains(got, "PresharedKey = "+base64PSK) {
-		t.Errorf("String() should encode PresharedKey as base64\ngot: %s", got)
+		t.Errorf("Marshal() should encode PresharedKey as base64\ngot: %s", got)
+	}
+}
+
+func TestConfig_String_Redacted(t *testing.T) {
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(text, "PrivateKey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=", "PrivateKey = "+wgnet.Redacted, 1)
+	want = strings.Replace(want, "PresharedKey = AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", "PresharedKey = "+wgnet.Redacted, 1)
+	if got := cfg.String(); got != want {
+		t.Errorf("mismatch\n got: %s\nwant: %s\n", got, want)
+	}
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q"} {
+		got := fmt.Sprintf(verb, cfg)
+		if strings.Contains(got, "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=") || !strings.Contains(got, wgnet.Redacted) {
+			t.Errorf("%s leaks secrets: %s", verb, got)
+		}
+	}
+	rawKey := strings.Trim(fmt.Sprint(cfg.PresharedKey), "[]")
+	for _, v := range []any{*cfg, struct{ Cfg wgnet.Config }{*cfg}, []wgnet.Config{*cfg}} {
+		for _, verb := range []string{"%v", "%+v", "%#v"} {
+			got := fmt.Sprintf(verb, v)
+			if strings.Contains(got, rawKey) || strings.Contains(got, "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=") || !strings.Contains(got, wgnet.Redacted) {
+				t.Errorf("%s of %T leaks secrets: %s", verb, v, got)
+			}
+		}
+	}
+}
+
+func TestConfig_LogValue_Redacted(t *testing.T) {
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, h := range []slog.Handler{slog.NewTextHandler(&buf, nil), slog.NewJSONHandler(&buf, nil)} {
+		slog.New(h).Info("config", "cfg", cfg, "val", *cfg)
+	}
+	if got := buf.String(); strings.Contains(got, "PrivateKey = WDE5") ||
+		strings.Contains(got, "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=") || strings.Count(got, wgnet.Redacted) != 8 {
+		t.Errorf("slog leaks secrets: %s", got)
 	}
 }
 
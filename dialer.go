@@ -0,0 +1,255 @@
+package wgnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+var (
+	ErrUnsupportedOption = errors.New("option not supported by netstack")
+	ErrNoSuitableAddress = errors.New("no suitable address found")
+)
+
+// defaultFallbackDelay is the Happy Eyeballs delay used by net.Dialer.
+const defaultFallbackDelay = time.Millisecond * 300
+
+// Dialer contains options for connecting to an address through a WgNet,
+// mirroring net.Dialer. The zero value of each option means no limit or
+// the default behaviour.
+type Dialer struct {
+	WgNet *WgNet
+	// Timeout is the maximum time a dial will wait for a connect to
+	// complete, including name resolution.
+	Timeout time.Duration
+	// Deadline is the absolute time after which a dial will fail.
+	Deadline time.Time
+	// LocalAddr is the local address to use when dialing, and must be a
+	// *net.UDPAddr for UDP or a *netstack.PingAddr for ping. netstack does
+	// not support choosing the local address for TCP.
+	LocalAddr net.Addr
+	// KeepAlive must be zero or negative, as netstack does not expose
+	// TCP keep-alive. It exists for compatibility with net.Dialer.
+	KeepAlive time.Duration
+	// Resolver resolves host names. If nil, names are resolved using
+	// the DNS servers of the WgNet through the tunnel.
+	Resolver *net.Resolver
+	// FallbackDelay is how long to wait for a TCP connection to the
+	// primary address family before racing the other family, if the host
+	// resolves to both. Zero means 300ms and negative disables racing.
+	FallbackDelay time.Duration
+}
+
+// Dial connects to address on the named network using context.Background.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address on the named network through the tunnel.
+// Supported networks are "tcp", "udp" and "ping", optionally suffixed with
+// "4" or "6". For "ping", address has no port.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	var cancel context.CancelFunc
+	if d.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	if !d.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, d.Deadline)
+		defer cancel()
+	}
+	proto := strings.TrimRight(network, "46")
+	if err = d.checkOptions(proto); err == nil {
+		var addrs []netip.AddrPort
+		if addrs, err = d.resolve(ctx, network, proto, address); err == nil {
+			var ns *netstack.Net
+			if ns, err = d.WgNet.getnet(); err == nil {
+				start := time.Now()
+				primaries, fallbacks := partitionAddrs(addrs)
+				if proto == "tcp" && d.FallbackDelay >= 0 && len(fallbacks) > 0 {
+					conn, err = d.dialParallel(ctx, ns, proto, primaries, fallbacks)
+				} else {
+					conn, err = d.dialSerial(ctx, ns, proto, addrs)
+				}
+				if err == nil {
+					conn = d.WgNet.trackConn(conn)
+				}
+				d.WgNet.observeDial(network, start, err)
+			}
+		}
+	}
+	if err != nil {
+		err = &net.OpError{Op: "dial", Net: network, Source: d.LocalAddr, Err: err}
+	}
+	return
+}
+
+func (d *Dialer) checkOptions(proto string) (err error) {
+	switch proto {
+	case "tcp":
+		if d.LocalAddr != nil || d.KeepAlive > 0 {
+			err = ErrUnsupportedOption
+		}
+	case "udp":
+		if _, ok := d.LocalAddr.(*net.UDPAddr); d.LocalAddr != nil && !ok {
+			err = ErrUnsupportedOption
+		}
+	case "ping":
+		if _, ok := d.LocalAddr.(*netstack.PingAddr); d.LocalAddr != nil && !ok {
+			err = ErrUnsupportedOption
+		}
+	default:
+		err = ErrUnsupportedNetwork
+	}
+	return
+}
+
+func (d *Dialer) resolve(ctx context.Context, network, proto, address string) (addrs []netip.AddrPort, err error) {
+	host := address
+	var port int
+	if proto != "ping" {
+		var service string
+		if host, service, err = net.SplitHostPort(address); err == nil {
+			if port, err = strconv.Atoi(service); err != nil {
+				port, err = net.LookupPort(proto, service)
+			}
+		}
+	}
+	if err == nil {
+		var ips []netip.Addr
+		if ip, e := netip.ParseAddr(host); e == nil {
+			ips = append(ips, ip)
+		} else if d.Resolver != nil {
+			ips, err = d.Resolver.LookupNetIP(ctx, "ip", host)
+		} else {
+			var hosts []string
+			if hosts, err = d.WgNet.LookupHost(ctx, host); err == nil {
+				for _, h := range hosts {
+					if ip, e := netip.ParseAddr(h); e == nil {
+						ips = append(ips, ip)
+					}
+				}
+			}
+		}
+		if err == nil {
+			for _, ip := range ips {
+				ip = ip.Unmap()
+				if (ip.Is4() && !strings.HasSuffix(network, "6")) || (ip.Is6() && !strings.HasSuffix(network, "4")) {
+					addrs = append(addrs, netip.AddrPortFrom(ip, uint16(port))) // #nosec G115
+				}
+			}
+			if len(addrs) == 0 {
+				err = ErrNoSuitableAddress
+			}
+		}
+	}
+	return
+}
+
+// partitionAddrs splits addrs into those of the same family as the first
+// address and the rest.
+func partitionAddrs(addrs []netip.AddrPort) (primaries, fallbacks []netip.AddrPort) {
+	for _, ap := range addrs {
+		if ap.Addr().Is4() == addrs[0].Addr().Is4() {
+			primaries = append(primaries, ap)
+		} else {
+			fallbacks = append(fallbacks, ap)
+		}
+	}
+	return
+}
+
+func (d *Dialer) dialOne(ctx context.Context, ns *netstack.Net, proto string, ap netip.AddrPort) (conn net.Conn, err error) {
+	switch proto {
+	case "tcp":
+		conn, err = ns.DialContextTCPAddrPort(ctx, ap)
+	case "udp":
+		var laddr netip.AddrPort
+		if ua, ok := d.LocalAddr.(*net.UDPAddr); ok {
+			laddr = ua.AddrPort()
+		}
+		conn, err = ns.DialUDPAddrPort(laddr, ap)
+	case "ping":
+		var laddr netip.Addr
+		if pa, ok := d.LocalAddr.(*netstack.PingAddr); ok {
+			laddr = pa.Addr()
+		}
+		conn, err = ns.DialPingAddr(laddr, ap.Addr())
+	}
+	return
+}
+
+func (d *Dialer) dialSerial(ctx context.Context, ns *netstack.Net, proto string, addrs []netip.AddrPort) (conn net.Conn, err error) {
+	for _, ap := range addrs {
+		if err = ctx.Err(); err == nil {
+			var e error
+			if conn, e = d.dialOne(ctx, ns, proto, ap); e == nil {
+				return
+			}
+			err = e
+		}
+	}
+	return
+}
+
+type dialResult struct {
+	conn    net.Conn
+	err     error
+	primary bool
+}
+
+// dialParallel races primaries against fallbacks, giving the primaries
+// a head start of FallbackDelay, as described in RFC 8305.
+func (d *Dialer) dialParallel(ctx context.Context, ns *netstack.Net, proto string, primaries, fallbacks []netip.AddrPort) (conn net.Conn, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult)
+	race := func(primary bool, addrs []netip.AddrPort) {
+		c, e := d.dialSerial(ctx, ns, proto, addrs)
+		select {
+		case results <- dialResult{conn: c, err: e, primary: primary}:
+		case <-ctx.Done():
+			if c != nil {
+				_ = c.Close()
+			}
+		}
+	}
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	go race(true, primaries)
+	pending, fallbackStarted := 1, false
+	startFallback := func() {
+		if !fallbackStarted {
+			fallbackStarted = true
+			pending++
+			go race(false, fallbacks)
+		}
+	}
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			startFallback()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if err == nil || res.primary {
+				err = res.err
+			}
+			startFallback()
+		}
+	}
+	return
+}
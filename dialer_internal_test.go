@@ -0,0 +1,55 @@
+package wgnet
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+func TestDialer_DialParallel(t *testing.T) {
+	v4 := netip.MustParseAddr("10.0.0.1")
+	v6 := netip.MustParseAddr("fd00::1")
+	tdev, ns, err := netstack.CreateNetTUN([]netip.Addr{v4, v6}, nil, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdev.Close()
+	l, err := ns.ListenTCPAddrPort(netip.AddrPortFrom(v4, 80))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	d := &Dialer{FallbackDelay: time.Second * 5}
+	start := time.Now()
+	conn, err := d.dialParallel(ctx, ns, "tcp", []netip.AddrPort{netip.AddrPortFrom(v6, 80)}, []netip.AddrPort{netip.AddrPortFrom(v4, 80)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ap := netip.MustParseAddrPort(conn.RemoteAddr().String()); ap.Addr() != v4 {
+		t.Error(ap)
+	}
+	// a refused primary starts the fallback without waiting for FallbackDelay
+	if elapsed := time.Since(start); elapsed >= d.FallbackDelay {
+		t.Error(elapsed)
+	}
+
+	if _, err = d.dialParallel(ctx, ns, "tcp", []netip.AddrPort{netip.AddrPortFrom(v6, 81)}, []netip.AddrPort{netip.AddrPortFrom(v4, 81)}); err == nil {
+		t.Error("expected error")
+	}
+}
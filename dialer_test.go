@@ -0,0 +1,91 @@
+package wgnet_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+func TestDialer(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	l, err := srv.Listen("tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = c.Write([]byte("hello"))
+			_ = c.Close()
+		}
+	}()
+	pc, err := srv.ListenPacket("udp", "10.131.132.1:53")
+	maybeFatal(t, err)
+	defer pc.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+
+	d := &wgnet.Dialer{WgNet: cli, Timeout: time.Second * 5}
+	c, err := d.DialContext(ctx, "tcp4", "10.131.132.1:80")
+	maybeFatal(t, err)
+	buf := make([]byte, 5)
+	_, err = c.Read(buf)
+	maybeFatal(t, err)
+	if string(buf) != "hello" {
+		t.Error(string(buf))
+	}
+	maybeFatal(t, c.Close())
+
+	d.LocalAddr = &net.UDPAddr{IP: net.IPv4(10, 131, 132, 2), Port: 5353}
+	c, err = d.DialContext(ctx, "udp", "10.131.132.1:53")
+	maybeFatal(t, err)
+	_, err = c.Write([]byte("ping"))
+	maybeFatal(t, err)
+	maybeFatal(t, pc.SetReadDeadline(time.Now().Add(time.Second*5)))
+	_, from, err := pc.ReadFrom(buf)
+	maybeFatal(t, err)
+	if from.String() != "10.131.132.2:5353" {
+		t.Error(from)
+	}
+	maybeFatal(t, c.Close())
+
+	if _, err = d.DialContext(ctx, "tcp", "10.131.132.1:80"); !errors.Is(err, wgnet.ErrUnsupportedOption) {
+		t.Errorf("expected %v, got %v", wgnet.ErrUnsupportedOption, err)
+	}
+
+	d.LocalAddr = &net.TCPAddr{Port: 4242}
+	if _, err = d.DialContext(ctx, "tcp", "10.131.132.1:80"); !errors.Is(err, wgnet.ErrUnsupportedOption) {
+		t.Errorf("expected %v, got %v", wgnet.ErrUnsupportedOption, err)
+	}
+	d.LocalAddr = nil
+	d.KeepAlive = time.Second
+	if _, err = d.DialContext(ctx, "tcp", "10.131.132.1:80"); !errors.Is(err, wgnet.ErrUnsupportedOption) {
+		t.Errorf("expected %v, got %v", wgnet.ErrUnsupportedOption, err)
+	}
+
+	d = &wgnet.Dialer{WgNet: cli}
+	if _, err = d.DialContext(ctx, "tcp6", "10.131.132.1:80"); !errors.Is(err, wgnet.ErrNoSuitableAddress) {
+		t.Errorf("expected %v, got %v", wgnet.ErrNoSuitableAddress, err)
+	}
+	if _, err = d.DialContext(ctx, "unix", "/tmp/socket"); !errors.Is(err, wgnet.ErrUnsupportedNetwork) {
+		t.Errorf("expected %v, got %v", wgnet.ErrUnsupportedNetwork, err)
+	}
+	d.Deadline = time.Now().Add(-time.Second)
+	if _, err = d.DialContext(ctx, "tcp", "10.131.132.1:80"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
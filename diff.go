@@ -0,0 +1,261 @@
+package wgnet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Change describes a Config field that differs between two Configs.
+// Secret keys are shown as Redacted.
+type Change struct {
+	Field string // e.g. "[Peer] Endpoint"
+	Old   string
+	New   string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Old, c.New)
+}
+
+// ConfigDiff is the set of changes between two Configs.
+type ConfigDiff struct {
+	Changes []Change
+	// Uapi is the minimal UAPI configuration that applies the changes to a
+	// running device using (*WgNet).IpcSet. It is empty if there are no
+	// changes that UAPI can apply.
+	Uapi string
+	// NeedsReopen is true if some changes, such as to Addresses or DNS,
+	// cannot be applied using UAPI and require reopening the WgNet.
+	NeedsReopen bool
+}
+
+// Empty returns true if there are no changes.
+func (d *ConfigDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+func joinPrefixes(prefixes []netip.Prefix) string {
+	var s []string
+	for _, pf := range prefixes {
+		s = append(s, pf.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	var s []string
+	for _, addr := range addrs {
+		s = append(s, addr.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func joinAddrPorts(addrports []netip.AddrPort) string {
+	var s []string
+	for _, ap := range addrports {
+		s = append(s, ap.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func addrPortString(ap netip.AddrPort) (s string) {
+	if ap.IsValid() {
+		s = ap.String()
+	}
+	return
+}
+
+func redactedKey(key []byte) (s string) {
+	if len(key) > 0 {
+		s = Redacted
+	}
+	return
+}
+
+// Diff returns the changes needed to turn old into new.
+// Both must be non-nil.
+func Diff(old, new *Config) (d *ConfigDiff) {
+	d = &ConfigDiff{}
+	add := func(field, o, n string) {
+		d.Changes = append(d.Changes, Change{Field: field, Old: o, New: n})
+	}
+
+	var uapi strings.Builder
+	if !bytes.Equal(old.PrivateKey, new.PrivateKey) {
+		add("[Interface] PrivateKey", redactedKey(old.PrivateKey), redactedKey(new.PrivateKey))
+		fmt.Fprintf(&uapi, "private_key=%x\n", new.PrivateKey)
+	}
+	if old.ListenPort != new.ListenPort {
+		add("[Interface] ListenPort", strconv.Itoa(old.ListenPort), strconv.Itoa(new.ListenPort))
+		fmt.Fprintf(&uapi, "listen_port=%d\n", new.ListenPort)
+	}
+	if !slices.Equal(old.Addresses, new.Addresses) {
+		add("[Interface] Address", joinPrefixes(old.Addresses), joinPrefixes(new.Addresses))
+		d.NeedsReopen = true
+	}
+	if !slices.Equal(old.DNS, new.DNS) {
+		add("[Interface] DNS", joinAddrs(old.DNS), joinAddrs(new.DNS))
+		d.NeedsReopen = true
+	}
+	if !slices.Equal(old.SecureDNS, new.SecureDNS) {
+		add("[Interface] SecureDNS", strings.Join(old.SecureDNS, ","), strings.Join(new.SecureDNS, ","))
+		d.NeedsReopen = true
+	}
+	if !slices.Equal(old.SearchDomains, new.SearchDomains) {
+		add("[Interface] SearchDomains", strings.Join(old.SearchDomains, ","), strings.Join(new.SearchDomains, ","))
+		d.NeedsReopen = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(mergeHosts(old.Hosts, new.Hosts))) {
+		if !slices.Equal(old.Hosts[name], new.Hosts[name]) {
+			add("[Hosts] "+name, joinAddrs(old.Hosts[name]), joinAddrs(new.Hosts[name]))
+			d.NeedsReopen = true
+		}
+	}
+	if old.LogLevel != new.LogLevel {
+		add("LogLevel", strconv.Itoa(old.LogLevel), strconv.Itoa(new.LogLevel))
+		d.NeedsReopen = true
+	}
+
+	newPeer := !bytes.Equal(old.PublicKey, new.PublicKey)
+	if newPeer {
+		add("[Peer] PublicKey", base64.StdEncoding.EncodeToString(old.PublicKey), base64.StdEncoding.EncodeToString(new.PublicKey))
+		fmt.Fprintf(&uapi, "public_key=%x\nremove=true\n", old.PublicKey)
+	}
+	var peer strings.Builder
+	if old.Endpoint != new.Endpoint {
+		add("[Peer] Endpoint", addrPortString(old.Endpoint), addrPortString(new.Endpoint))
+		if new.Endpoint.IsValid() {
+			fmt.Fprintf(&peer, "endpoint=%s\n", new.Endpoint.String())
+		} else {
+			// UAPI cannot clear an endpoint
+			d.NeedsReopen = true
+		}
+	}
+	if !slices.Equal(old.FallbackEndpoints, new.FallbackEndpoints) {
+		add("[Peer] FallbackEndpoints", joinAddrPorts(old.FallbackEndpoints), joinAddrPorts(new.FallbackEndpoints))
+		d.NeedsReopen = true
+	}
+	if !bytes.Equal(old.PresharedKey, new.PresharedKey) {
+		add("[Peer] PresharedKey", redactedKey(old.PresharedKey), redactedKey(new.PresharedKey))
+		psk := new.PresharedKey
+		if len(psk) == 0 {
+			psk = make([]byte, 32)
+		}
+		fmt.Fprintf(&peer, "preshared_key=%x\n", psk)
+	}
+	if old.PersistentKeepalive != new.PersistentKeepalive {
+		add("[Peer] PersistentKeepalive", strconv.Itoa(old.PersistentKeepalive), strconv.Itoa(new.PersistentKeepalive))
+		fmt.Fprintf(&peer, "persistent_keepalive_interval=%d\n", new.PersistentKeepalive)
+	}
+	if !slices.Equal(old.AllowedIPs, new.AllowedIPs) {
+		add("[Peer] AllowedIPs", joinPrefixes(old.AllowedIPs), joinPrefixes(new.AllowedIPs))
+		peer.WriteString("replace_allowed_ips=true\n")
+		for _, pf := range new.AllowedIPs {
+			fmt.Fprintf(&peer, "allowed_ip=%s\n", pf.String())
+		}
+	}
+	if newPeer {
+		fmt.Fprintf(&uapi, "public_key=%x\n", new.PublicKey)
+		if new.Endpoint.IsValid() && old.Endpoint == new.Endpoint {
+			fmt.Fprintf(&uapi, "endpoint=%s\n", new.Endpoint.String())
+		}
+		if len(new.PresharedKey) > 0 && bytes.Equal(old.PresharedKey, new.PresharedKey) {
+			fmt.Fprintf(&uapi, "preshared_key=%x\n", new.PresharedKey)
+		}
+		if new.PersistentKeepalive > 0 && old.PersistentKeepalive == new.PersistentKeepalive {
+			fmt.Fprintf(&uapi, "persistent_keepalive_interval=%d\n", new.PersistentKeepalive)
+		}
+		uapi.WriteString(peer.String())
+		if slices.Equal(old.AllowedIPs, new.AllowedIPs) {
+			for _, pf := range new.AllowedIPs {
+				fmt.Fprintf(&uapi, "allowed_ip=%s\n", pf.String())
+			}
+		}
+	} else if peer.Len() > 0 {
+		fmt.Fprintf(&uapi, "public_key=%x\nupdate_only=true\n", new.PublicKey)
+		uapi.WriteString(peer.String())
+	}
+	d.Uapi = uapi.String()
+	return
+}
+
+// mergeHosts returns a copy of base with the entries of overlay added,
+// or nil if both are empty.
+func mergeHosts(base, overlay map[string][]netip.Addr) (hosts map[string][]netip.Addr) {
+	for _, m := range []map[string][]netip.Addr{base, overlay} {
+		for name, addrs := range m {
+			if hosts == nil {
+				hosts = make(map[string][]netip.Addr)
+			}
+			hosts[name] = slices.Clone(addrs)
+		}
+	}
+	return
+}
+
+// Merge returns a new Config with the fields of base overridden by the
+// fields that are set in overlay, for layering site defaults (base) onto
+// per-user configs (overlay). Both must be non-nil. Slices are replaced,
+// not appended, while Hosts entries from overlay are added to those of base.
+func Merge(base, overlay *Config) *Config {
+	cfg := &Config{
+		Addresses:           slices.Clone(base.Addresses),
+		PrivateKey:          slices.Clone(base.PrivateKey),
+		PublicKey:           slices.Clone(base.PublicKey),
+		PresharedKey:        slices.Clone(base.PresharedKey),
+		Endpoint:            base.Endpoint,
+		FallbackEndpoints:   slices.Clone(base.FallbackEndpoints),
+		AllowedIPs:          slices.Clone(base.AllowedIPs),
+		DNS:                 slices.Clone(base.DNS),
+		SecureDNS:           slices.Clone(base.SecureDNS),
+		SearchDomains:       slices.Clone(base.SearchDomains),
+		Hosts:               mergeHosts(base.Hosts, overlay.Hosts),
+		ListenPort:          base.ListenPort,
+		LogLevel:            base.LogLevel,
+		PersistentKeepalive: base.PersistentKeepalive,
+	}
+	if len(overlay.Addresses) > 0 {
+		cfg.Addresses = slices.Clone(overlay.Addresses)
+	}
+	if len(overlay.PrivateKey) > 0 {
+		cfg.PrivateKey = slices.Clone(overlay.PrivateKey)
+	}
+	if len(overlay.PublicKey) > 0 {
+		cfg.PublicKey = slices.Clone(overlay.PublicKey)
+	}
+	if len(overlay.PresharedKey) > 0 {
+		cfg.PresharedKey = slices.Clone(overlay.PresharedKey)
+	}
+	if overlay.Endpoint.IsValid() {
+		cfg.Endpoint = overlay.Endpoint
+		cfg.FallbackEndpoints = slices.Clone(overlay.FallbackEndpoints)
+	}
+	if len(overlay.AllowedIPs) > 0 {
+		cfg.AllowedIPs = slices.Clone(overlay.AllowedIPs)
+	}
+	if len(overlay.DNS) > 0 {
+		cfg.DNS = slices.Clone(overlay.DNS)
+	}
+	if len(overlay.SecureDNS) > 0 {
+		cfg.SecureDNS = slices.Clone(overlay.SecureDNS)
+	}
+	if len(overlay.SearchDomains) > 0 {
+		cfg.SearchDomains = slices.Clone(overlay.SearchDomains)
+	}
+	if overlay.ListenPort > 0 {
+		cfg.ListenPort = overlay.ListenPort
+	}
+	if overlay.LogLevel > 0 {
+		cfg.LogLevel = overlay.LogLevel
+	}
+	if overlay.PersistentKeepalive > 0 {
+		cfg.PersistentKeepalive = overlay.PersistentKeepalive
+	}
+	return cfg
+}
@@ -0,0 +1,131 @@
+package wgnet_test
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+func TestDiff_Empty(t *testing.T) {
+	a, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	b, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	d := wgnet.Diff(a, b)
+	if !d.Empty() || d.Uapi != "" || d.NeedsReopen {
+		t.Errorf("unexpected %#v", d)
+	}
+}
+
+func TestDiff_SamePeer(t *testing.T) {
+	a, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	b, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	b.Endpoint = netip.MustParseAddrPort("10.0.0.2:2")
+	b.PresharedKey = nil
+	b.PersistentKeepalive = 25
+	b.AllowedIPs = b.AllowedIPs[:1]
+	b.DNS = nil
+	b.SearchDomains = []string{"corp.internal"}
+	d := wgnet.Diff(a, b)
+	if len(d.Changes) != 6 || !d.NeedsReopen {
+		t.Errorf("unexpected %v", d.Changes)
+	}
+	want := `public_key=583139415432556c50456641504765764c4c58745446783932554f4e59383351
+update_only=true
+endpoint=10.0.0.2:2
+preshared_key=0000000000000000000000000000000000000000000000000000000000000000
+persistent_keepalive_interval=25
+replace_allowed_ips=true
+allowed_ip=192.168.1.0/24
+`
+	if d.Uapi != want {
+		t.Errorf("mismatch\n got: %s\nwant: %s\n", d.Uapi, want)
+	}
+	for _, c := range d.Changes {
+		if strings.Contains(c.String(), "AAECAwQF") {
+			t.Errorf("change leaks secret: %v", c)
+		}
+	}
+}
+
+func TestDiff_NewPeer(t *testing.T) {
+	a, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	b, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	b.PublicKey = decodeKey("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	b.ListenPort = 7000
+	d := wgnet.Diff(a, b)
+	want := `listen_port=7000
+public_key=583139415432556c50456641504765764c4c58745446783932554f4e59383351
+remove=true
+public_key=000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+endpoint=10.0.0.1:1
+preshared_key=000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+persistent_keepalive_interval=10
+allowed_ip=192.168.1.0/24
+allowed_ip=10.0.0.0/8
+`
+	if d.Uapi != want {
+		t.Errorf("mismatch\n got: %s\nwant: %s\n", d.Uapi, want)
+	}
+	if d.NeedsReopen {
+		t.Error("unexpected NeedsReopen")
+	}
+}
+
+func TestDiff_AppliesToDevice(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	old, err := cli.Snapshot()
+	maybeFatal(t, err)
+	want := wgnet.Merge(old, &wgnet.Config{PersistentKeepalive: 15})
+	maybeFatal(t, cli.IpcSet(wgnet.Diff(old, want).Uapi))
+	got, err := cli.Snapshot()
+	maybeFatal(t, err)
+	if d := wgnet.Diff(got, want); !d.Empty() {
+		t.Errorf("unexpected %v", d.Changes)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	_, err = cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	site, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, 51820)), nil)
+	maybeFatal(t, err)
+	user := &wgnet.Config{
+		Addresses:           []netip.Prefix{netip.MustParsePrefix("10.131.132.9/24")},
+		PrivateKey:          decodeKey("WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E="),
+		PersistentKeepalive: 25,
+	}
+	cfg := wgnet.Merge(site, user)
+	if cfg.Addresses[0] != user.Addresses[0] || cfg.PersistentKeepalive != 25 {
+		t.Error(cfg)
+	}
+	if cfg.Endpoint != site.Endpoint || len(cfg.AllowedIPs) != 2 {
+		t.Error(cfg)
+	}
+	cfg.AllowedIPs[0] = netip.Prefix{}
+	if !site.AllowedIPs[0].IsValid() {
+		t.Error("Merge must not share slices with base")
+	}
+	if problems := cfg.Validate(); len(problems) != 0 {
+		t.Error(problems)
+	}
+}
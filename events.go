@@ -0,0 +1,170 @@
+package wgnet
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// EventKind identifies the kind of an Event.
+type EventKind int
+
+const (
+	EventOpened           EventKind = iota + 1 // the WgNet was opened
+	EventClosed                                // the WgNet was closed
+	EventHandshake                             // a handshake with a peer completed
+	EventHandshakeFailing                      // handshakes with a peer have been failing for Event.Since
+	EventEndpointRoamed                        // the endpoint of a peer changed to Event.Endpoint
+	EventPeerAdded                             // a peer was added to the device
+	EventPeerRemoved                           // a peer was removed from the device
+	EventReleased                              // a closed device has released its OS resources
+)
+
+var eventKindNames = map[EventKind]string{
+	EventOpened:           "opened",
+	EventClosed:           "closed",
+	EventHandshake:        "handshake",
+	EventHandshakeFailing: "handshake failing",
+	EventEndpointRoamed:   "endpoint roamed",
+	EventPeerAdded:        "peer added",
+	EventPeerRemoved:      "peer removed",
+	EventReleased:         "released",
+}
+
+func (k EventKind) String() string {
+	return eventKindNames[k]
+}
+
+// Event describes a change in the lifecycle of a WgNet.
+type Event struct {
+	Kind      EventKind
+	Time      time.Time
+	PublicKey []byte         // the peer, for peer events
+	Endpoint  netip.AddrPort // the peer endpoint, for peer events
+	Since     time.Duration  // for EventHandshakeFailing, how long handshakes have been failing
+}
+
+const (
+	// monitorInterval is how often the device status is polled for events.
+	monitorInterval = time.Second
+	// handshakeFailingAfter is the default for SetHandshakeFailingAfter.
+	handshakeFailingAfter = device.RekeyTimeout
+)
+
+// Subscribe registers fn to be called for each Event. fn must not block.
+// EventHandshakeFailing is delivered once packets sent to a peer without a
+// usable session have gone unanswered for the SetHandshakeFailingAfter
+// duration, and again only after an EventHandshake for that peer.
+// Call the returned function to unsubscribe.
+func (wgnet *WgNet) Subscribe(fn func(Event)) (unsubscribe func()) {
+	wgnet.evmu.Lock()
+	defer wgnet.evmu.Unlock()
+	if wgnet.subs == nil {
+		wgnet.subs = make(map[int]func(Event))
+	}
+	wgnet.nextsub++
+	id := wgnet.nextsub
+	wgnet.subs[id] = fn
+	return func() {
+		wgnet.evmu.Lock()
+		delete(wgnet.subs, id)
+		wgnet.evmu.Unlock()
+	}
+}
+
+// SetHandshakeFailingAfter sets how long packets sent to a peer must go
+// without a completed handshake before EventHandshakeFailing is delivered.
+// Zero or negative restores the default of device.RekeyTimeout.
+func (wgnet *WgNet) SetHandshakeFailingAfter(d time.Duration) {
+	wgnet.hsfail.Store(int64(d))
+}
+
+func (wgnet *WgNet) failingAfter() (d time.Duration) {
+	if d = time.Duration(wgnet.hsfail.Load()); d <= 0 {
+		d = handshakeFailingAfter
+	}
+	return
+}
+
+func (wgnet *WgNet) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	wgnet.evmu.Lock()
+	subs := make([]func(Event), 0, len(wgnet.subs))
+	for _, fn := range wgnet.subs {
+		subs = append(subs, fn)
+	}
+	wgnet.evmu.Unlock()
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// peerMonitor tracks the state of a peer between polls.
+type peerMonitor struct {
+	PeerStatus
+	failingSince time.Time // first unanswered send, zero if none
+	reported     bool      // EventHandshakeFailing was emitted
+}
+
+// monitor polls the status of dev until stop is closed,
+// emitting events for changes it detects.
+func (wgnet *WgNet) monitor(dev *device.Device, stop <-chan struct{}) {
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+	peers := map[string]*peerMonitor{}
+	var fo failover
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if uapi, err := dev.IpcGet(); err == nil {
+				if st, err := ParseUapiStatus(strings.NewReader(uapi)); err == nil {
+					wgnet.monitorPoll(now, st, peers)
+					wgnet.failoverPoll(now, dev, &fo, peers)
+					wgnet.peersPoll(st)
+				}
+			}
+			wgnet.idlePoll(now)
+		}
+	}
+}
+
+func (wgnet *WgNet) monitorPoll(now time.Time, st *Status, peers map[string]*peerMonitor) {
+	seen := map[string]bool{}
+	for _, ps := range st.Peers {
+		key := string(ps.PublicKey)
+		seen[key] = true
+		pm := peers[key]
+		if pm == nil {
+			pm = &peerMonitor{}
+			peers[key] = pm
+			wgnet.emit(Event{Kind: EventPeerAdded, Time: now, PublicKey: ps.PublicKey, Endpoint: ps.Endpoint})
+		}
+		if ps.LastHandshake.After(pm.LastHandshake) {
+			pm.failingSince, pm.reported = time.Time{}, false
+			wgnet.emit(Event{Kind: EventHandshake, Time: now, PublicKey: ps.PublicKey, Endpoint: ps.Endpoint})
+		} else if ps.TxBytes > pm.TxBytes && now.Sub(ps.LastHandshake) > device.RejectAfterTime && pm.failingSince.IsZero() {
+			// sending without a usable session starts a handshake
+			pm.failingSince = now
+		}
+		if !pm.failingSince.IsZero() && !pm.reported && now.Sub(pm.failingSince) >= wgnet.failingAfter() {
+			pm.reported = true
+			wgnet.emit(Event{Kind: EventHandshakeFailing, Time: now, PublicKey: ps.PublicKey, Endpoint: ps.Endpoint, Since: now.Sub(pm.failingSince)})
+		}
+		if pm.Endpoint.IsValid() && ps.Endpoint.IsValid() && pm.Endpoint != ps.Endpoint {
+			wgnet.emit(Event{Kind: EventEndpointRoamed, Time: now, PublicKey: ps.PublicKey, Endpoint: ps.Endpoint})
+		}
+		pm.PeerStatus = ps
+	}
+	for key, pm := range peers {
+		if !seen[key] {
+			delete(peers, key)
+			wgnet.emit(Event{Kind: EventPeerRemoved, Time: now, PublicKey: pm.PublicKey, Endpoint: pm.Endpoint})
+		}
+	}
+}
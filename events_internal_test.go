@@ -0,0 +1,68 @@
+package wgnet
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestMonitorPoll(t *testing.T) {
+	var wg WgNet
+	var events []Event
+	wg.Subscribe(func(ev Event) { events = append(events, ev) })
+	kinds := func() (k []EventKind) {
+		for _, ev := range events {
+			k = append(k, ev.Kind)
+		}
+		events = nil
+		return
+	}
+
+	peers := map[string]*peerMonitor{}
+	now := time.Now()
+	key := make([]byte, 32)
+	ep1 := netip.MustParseAddrPort("10.0.0.1:1")
+	ep2 := netip.MustParseAddrPort("10.0.0.2:1")
+
+	wg.monitorPoll(now, &Status{Peers: []PeerStatus{{PublicKey: key, Endpoint: ep1}}}, peers)
+	if k := kinds(); len(k) != 1 || k[0] != EventPeerAdded {
+		t.Fatal(k)
+	}
+
+	// unanswered sends are not failing until handshakeFailingAfter has passed
+	now = now.Add(time.Second)
+	failing := now
+	wg.monitorPoll(now, &Status{Peers: []PeerStatus{{PublicKey: key, Endpoint: ep1, TxBytes: 148}}}, peers)
+	if k := kinds(); len(k) != 0 {
+		t.Fatal(k)
+	}
+	now = failing.Add(handshakeFailingAfter - time.Second)
+	wg.monitorPoll(now, &Status{Peers: []PeerStatus{{PublicKey: key, Endpoint: ep1, TxBytes: 296}}}, peers)
+	if k := kinds(); len(k) != 0 {
+		t.Fatal(k)
+	}
+	now = failing.Add(handshakeFailingAfter)
+	wg.monitorPoll(now, &Status{Peers: []PeerStatus{{PublicKey: key, Endpoint: ep1, TxBytes: 296}}}, peers)
+	if len(events) != 1 || events[0].Kind != EventHandshakeFailing || events[0].Since != handshakeFailingAfter {
+		t.Fatal(events)
+	}
+	events = nil
+
+	now = now.Add(time.Second)
+	wg.monitorPoll(now, &Status{Peers: []PeerStatus{{PublicKey: key, Endpoint: ep1, TxBytes: 444}}}, peers)
+	if k := kinds(); len(k) != 0 {
+		t.Fatal(k)
+	}
+
+	now = now.Add(time.Second)
+	wg.monitorPoll(now, &Status{Peers: []PeerStatus{{PublicKey: key, Endpoint: ep2, TxBytes: 592, LastHandshake: now}}}, peers)
+	if k := kinds(); len(k) != 2 || k[0] != EventHandshake || k[1] != EventEndpointRoamed {
+		t.Fatal(k)
+	}
+
+	now = now.Add(time.Second)
+	wg.monitorPoll(now, &Status{}, peers)
+	if k := kinds(); len(k) != 1 || k[0] != EventPeerRemoved {
+		t.Fatal(k)
+	}
+}
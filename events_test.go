@@ -0,0 +1,124 @@
+package wgnet_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+// waitForEvent returns the next event of kind, failing on any
+// EventHandshakeFailing while waiting for another kind.
+func waitForEvent(t *testing.T, events <-chan wgnet.Event, kind wgnet.EventKind) (ev wgnet.Event) {
+	t.Helper()
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case ev = <-events:
+			if ev.Kind == kind {
+				return
+			}
+			if ev.Kind == wgnet.EventHandshakeFailing {
+				t.Fatalf("unexpected %v while waiting for %v", ev.Kind, kind)
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %v", kind)
+		}
+	}
+}
+
+func eventChannel(wg *wgnet.WgNet) (events chan wgnet.Event, unsubscribe func()) {
+	events = make(chan wgnet.Event, 100)
+	unsubscribe = wg.Subscribe(func(ev wgnet.Event) {
+		select {
+		case events <- ev:
+		default:
+		}
+	})
+	return
+}
+
+func TestWgNet_Events(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	cli := wgnet.New(cliCfg)
+	events, unsubscribe := eventChannel(cli)
+	defer unsubscribe()
+	maybeFatal(t, cli.Open())
+	waitForEvent(t, events, wgnet.EventOpened)
+	ev := waitForEvent(t, events, wgnet.EventPeerAdded)
+	if !strings.HasPrefix(ev.Kind.String(), "peer") || len(ev.PublicKey) != 32 {
+		t.Error(ev)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	_, err = cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	ev = waitForEvent(t, events, wgnet.EventHandshake)
+	if ev.Endpoint.Port() != uint16(listenPort) {
+		t.Error(ev.Endpoint)
+	}
+
+	maybeFatal(t, cli.Close())
+	waitForEvent(t, events, wgnet.EventClosed)
+}
+
+func TestWgNet_Events_HandshakeFailing(t *testing.T) {
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, 9)), nil)
+	maybeFatal(t, err)
+	cli := wgnet.New(cliCfg)
+	cli.SetHandshakeFailingAfter(time.Second * 2)
+	events, unsubscribe := eventChannel(cli)
+	defer unsubscribe()
+	maybeFatal(t, cli.Open())
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond*100)
+	defer cancel()
+	if _, err = cli.Ping4(ctx, "10.131.132.1"); err == nil {
+		t.Fatal("expected ping to fail")
+	}
+	if ev := waitForEvent(t, events, wgnet.EventHandshakeFailing); ev.Since < time.Second*2 {
+		t.Error(ev.Since)
+	}
+}
+
+func TestWgNet_Events_OpenFailed(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	dup := wgnet.New(srvCfg)
+	events, unsubscribe := eventChannel(dup)
+	defer unsubscribe()
+	if err = dup.Open(); err == nil {
+		t.Fatal("expected error")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %v", ev.Kind)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
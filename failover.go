@@ -0,0 +1,95 @@
+package wgnet
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+var (
+	// failoverAfter is how long handshakes must fail before switching
+	// to the next endpoint, and how long a trial of the primary Endpoint
+	// waits for a handshake.
+	failoverAfter = device.RekeyTimeout * 3
+	// failbackAfter is how long to stay on a fallback endpoint before
+	// trying the primary Endpoint again.
+	failbackAfter = time.Minute * 5
+)
+
+// failover tracks which of the Config endpoints the peer is using.
+type failover struct {
+	index      int
+	switchedAt time.Time
+	trying     bool // on trial of the primary Endpoint
+	prev       int  // index to return to if the trial fails
+}
+
+// endpoints returns the primary Endpoint followed by the FallbackEndpoints.
+func (cfg *Config) endpoints() (eps []netip.AddrPort) {
+	if cfg.Endpoint.IsValid() {
+		eps = append(eps, cfg.Endpoint)
+		eps = append(eps, cfg.FallbackEndpoints...)
+	}
+	return
+}
+
+// poll switches to the next endpoint if handshakes with the current one
+// have been failing for failoverAfter. Once failbackAfter has passed since
+// the last switch, it switches back to the primary endpoint on trial, and
+// returns to the fallback if the device gets no handshake within
+// failoverAfter. It returns the endpoint to switch to, if any.
+func (fo *failover) poll(now time.Time, cfg *Config, pm *peerMonitor) (ap netip.AddrPort) {
+	if eps := cfg.endpoints(); len(eps) > 1 && pm != nil {
+		switch {
+		case fo.trying:
+			if pm.LastHandshake.After(fo.switchedAt) {
+				fo.trying = false
+				return
+			}
+			if now.Sub(fo.switchedAt) < failoverAfter {
+				return
+			}
+			fo.trying = false
+			fo.index = fo.prev
+		case !pm.failingSince.IsZero() && now.Sub(pm.failingSince) >= failoverAfter:
+			fo.index = (fo.index + 1) % len(eps)
+		case fo.index != 0 && pm.failingSince.IsZero() && now.Sub(fo.switchedAt) >= failbackAfter:
+			fo.trying, fo.prev = true, fo.index
+			fo.index = 0
+		default:
+			return
+		}
+		pm.failingSince = time.Time{}
+		fo.switchedAt = now
+		ap = eps[fo.index]
+	}
+	return
+}
+
+func (wgnet *WgNet) failoverPoll(now time.Time, dev *device.Device, fo *failover, peers map[string]*peerMonitor) {
+	if ap := fo.poll(now, wgnet.cfg, peers[string(wgnet.cfg.PublicKey)]); ap.IsValid() {
+		_ = wgnet.rekey(dev, ap)
+	}
+}
+
+// rekey replaces the peer of dev with a copy using the endpoint ap. The copy
+// has no sessions, so the device starts a new handshake right away.
+func (wgnet *WgNet) rekey(dev *device.Device, ap netip.AddrPort) (err error) {
+	cfg := *wgnet.cfg
+	cfg.Endpoint = ap
+	if cfg.PersistentKeepalive == 0 {
+		// turning on keepalive sends one right away, which starts a handshake
+		cfg.PersistentKeepalive = 25
+	}
+	uapi := cfg.UapiConf()
+	uapi = uapi[strings.Index(uapi, "public_key="):]
+	if err = dev.IpcSet(fmt.Sprintf("public_key=%x\nremove=true\n%s", cfg.PublicKey, uapi)); err == nil {
+		if wgnet.cfg.PersistentKeepalive == 0 {
+			err = dev.IpcSet(fmt.Sprintf("public_key=%x\nupdate_only=true\npersistent_keepalive_interval=0\n", cfg.PublicKey))
+		}
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package wgnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailoverPoll(t *testing.T) {
+	ep1 := netip.MustParseAddrPort("10.0.0.1:1")
+	ep2 := netip.MustParseAddrPort("10.0.0.2:1")
+	cfg := &Config{Endpoint: ep1, FallbackEndpoints: []netip.AddrPort{ep2}}
+	now := time.Now()
+	pm := &peerMonitor{}
+	var fo failover
+
+	if ap := fo.poll(now, cfg, pm); ap.IsValid() {
+		t.Fatal(ap)
+	}
+	pm.failingSince = now
+	if ap := fo.poll(now.Add(failoverAfter-time.Second), cfg, pm); ap.IsValid() {
+		t.Fatal(ap)
+	}
+	now = now.Add(failoverAfter)
+	if ap := fo.poll(now, cfg, pm); ap != ep2 {
+		t.Fatal(ap)
+	}
+	if !pm.failingSince.IsZero() {
+		t.Fatal(pm.failingSince)
+	}
+
+	// still failing on the last endpoint wraps around to the first
+	pm.failingSince = now
+	now = now.Add(failoverAfter)
+	if ap := fo.poll(now, cfg, pm); ap != ep1 {
+		t.Fatal(ap)
+	}
+
+	pm.failingSince = now
+	now = now.Add(failoverAfter)
+	if ap := fo.poll(now, cfg, pm); ap != ep2 {
+		t.Fatal(ap)
+	}
+	// working fallback is kept until failbackAfter, then the primary is tried
+	if ap := fo.poll(now.Add(failbackAfter-time.Second), cfg, pm); ap.IsValid() {
+		t.Fatal(ap)
+	}
+	now = now.Add(failbackAfter)
+	if ap := fo.poll(now, cfg, pm); ap != ep1 || !fo.trying {
+		t.Fatal(ap)
+	}
+	// no handshake on the primary within failoverAfter returns to the fallback
+	if ap := fo.poll(now.Add(failoverAfter-time.Second), cfg, pm); ap.IsValid() {
+		t.Fatal(ap)
+	}
+	now = now.Add(failoverAfter)
+	if ap := fo.poll(now, cfg, pm); ap != ep2 || fo.trying {
+		t.Fatal(ap)
+	}
+	// a handshake on the primary ends the trial there
+	now = now.Add(failbackAfter)
+	if ap := fo.poll(now, cfg, pm); ap != ep1 {
+		t.Fatal(ap)
+	}
+	pm.LastHandshake = now.Add(time.Second)
+	if ap := fo.poll(now.Add(time.Second*2), cfg, pm); ap.IsValid() || fo.trying || fo.index != 0 {
+		t.Fatal(ap)
+	}
+
+	// no fallbacks means no failover
+	pm.failingSince = now
+	if ap := fo.poll(now.Add(failoverAfter), &Config{Endpoint: ep1}, pm); ap.IsValid() {
+		t.Fatal(ap)
+	}
+}
+
+func TestWgNet_Failback(t *testing.T) {
+	defer func(after, back time.Duration) {
+		failoverAfter, failbackAfter = after, back
+	}(failoverAfter, failbackAfter)
+	failoverAfter, failbackAfter = time.Second, time.Second*2
+
+	freePort := func() int {
+		pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+		return pc.LocalAddr().(*net.UDPAddr).Port
+	}
+	serve := func(port int) *WgNet {
+		cfg, err := Parse(strings.NewReader(fmt.Sprintf(`[Interface]
+PrivateKey = GInruesHOogIjjFsKCorYEAENAfYfPL/yH8ObFgyFUs=
+ListenPort = %d
+Address = 10.131.132.1/24
+
+[Peer]
+PublicKey = kTUQWHx4Y3ZYMZQPnRarzlx0qnen3plDoI0z7s45in4=
+AllowedIPs = 10.131.132.2/32
+`, port)), nil)
+		if err == nil {
+			wg := New(cfg)
+			if err = wg.Open(); err == nil {
+				return wg
+			}
+		}
+		t.Fatal(err)
+		return nil
+	}
+	endpoint := func(wg *WgNet) (ap netip.AddrPort) {
+		if st, err := wg.Status(); err == nil && len(st.Peers) == 1 {
+			ap = st.Peers[0].Endpoint
+		}
+		return
+	}
+	waitEndpoint := func(wg *WgNet, want netip.AddrPort) {
+		t.Helper()
+		// handshakes are retried every device.RekeyTimeout, so this takes a while
+		for deadline := time.Now().Add(time.Second * 20); time.Now().Before(deadline); {
+			// keep traffic flowing so handshakes are attempted
+			ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond*100)
+			_, _ = wg.Ping4(ctx, "10.131.132.1")
+			cancel()
+			if endpoint(wg) == want {
+				return
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		t.Fatalf("endpoint %v, want %v", endpoint(wg), want)
+	}
+
+	primary := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(freePort()))
+	fallback := serve(freePort())
+	defer fallback.Close()
+	cfg, err := Parse(strings.NewReader(fmt.Sprintf(`[Interface]
+PrivateKey = AEnvL9tVr+7JF0sMVjjzPjIxrrc/hoVJ5B82WWpVamI=
+Address = 10.131.132.2/24
+
+[Peer]
+PublicKey = Wh3yY7/fE3fyHJ8TOwLJ//CIRbgrlVl4bLQ+npNBSRU=
+Endpoint = %s, 127.0.0.1:%d
+AllowedIPs = 10.131.132.0/24
+`, primary, fallback.cfg.ListenPort)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := New(cfg)
+	if err = cli.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// the primary is down, so the client fails over
+	waitEndpoint(cli, cfg.FallbackEndpoints[0])
+
+	// trials of the primary fail while it is down, returning to the fallback
+	waitEndpoint(cli, primary)
+	waitEndpoint(cli, cfg.FallbackEndpoints[0])
+
+	// once the primary is up, a trial succeeds and the client stays there
+	srv := serve(int(primary.Port()))
+	defer srv.Close()
+	waitEndpoint(cli, primary)
+	time.Sleep(failoverAfter * 3)
+	if ap := endpoint(cli); ap != primary {
+		t.Fatal(ap)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*5)
+	defer cancel()
+	if _, err = cli.Ping4(ctx, "10.131.132.1"); err != nil {
+		t.Error(err)
+	}
+}
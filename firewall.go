@@ -0,0 +1,162 @@
+package wgnet
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"slices"
+)
+
+var ErrInvalidFirewallRule = errors.New("invalid firewall rule")
+
+// FirewallAction is what a Firewall does with a matching packet.
+type FirewallAction int
+
+const (
+	FirewallAccept FirewallAction = iota + 1 // deliver the packet
+	FirewallDrop                             // silently discard the packet
+)
+
+// FirewallDirection selects packets by the way they cross the tunnel.
+type FirewallDirection int
+
+const (
+	FirewallInbound  FirewallDirection = iota + 1 // received from a peer, going to the netstack
+	FirewallOutbound                              // sent by the netstack, going to a peer
+)
+
+// PortRange is an inclusive range of TCP or UDP ports.
+type PortRange struct {
+	From, To uint16
+}
+
+func (pr PortRange) contains(port uint16) bool {
+	return port >= pr.From && port <= pr.To
+}
+
+// FirewallRule matches packets on all of its non-empty fields.
+type FirewallRule struct {
+	Action    FirewallAction
+	Direction FirewallDirection // zero matches both directions
+	Src       []netip.Prefix    // the source address is in one of these
+	Dst       []netip.Prefix    // the destination address is in one of these
+	Protocol  string            // "tcp", "udp" or "icmp", which includes ICMPv6
+	SrcPorts  []PortRange       // the TCP or UDP source port is in one of these
+	DstPorts  []PortRange       // the TCP or UDP destination port is in one of these
+	PublicKey []byte            // the packet comes from or goes to this peer
+}
+
+// Firewall filters the packets passing between the netstack and the
+// WireGuard device. The first rule matching a packet decides its fate,
+// and packets matching no rule get the Default action, or are accepted if
+// Default is zero. Rules are stateless, so replies must be accepted as well.
+type Firewall struct {
+	Rules   []FirewallRule
+	Default FirewallAction
+}
+
+var firewallProtocols = map[string][]uint8{
+	"":     nil,
+	"tcp":  {protoTCP},
+	"udp":  {protoUDP},
+	"icmp": {protoICMP, protoICMPv6},
+}
+
+// firewall is a validated Firewall in use by a WgNet.
+type firewall struct {
+	rules  []FirewallRule
+	protos [][]uint8 // for each rule, the protocol numbers, nil for any
+	dflt   FirewallAction
+	ports  bool // some rule matches ports
+}
+
+func newFirewall(fw *Firewall) (f *firewall, err error) {
+	f = &firewall{rules: slices.Clone(fw.Rules), dflt: fw.Default}
+	if f.dflt == 0 {
+		f.dflt = FirewallAccept
+	}
+	if f.dflt != FirewallAccept && f.dflt != FirewallDrop {
+		err = ErrInvalidFirewallRule
+	}
+	for _, r := range f.rules {
+		protos, ok := firewallProtocols[r.Protocol]
+		if !ok || (r.Action != FirewallAccept && r.Action != FirewallDrop) ||
+			r.Direction < 0 || r.Direction > FirewallOutbound ||
+			(len(r.PublicKey) != 0 && len(r.PublicKey) != 32) ||
+			(r.Protocol == "icmp" && len(r.SrcPorts)+len(r.DstPorts) > 0) {
+			err = ErrInvalidFirewallRule
+		}
+		f.protos = append(f.protos, protos)
+		f.ports = f.ports || len(r.SrcPorts)+len(r.DstPorts) > 0
+	}
+	return
+}
+
+// allow returns true if the packet pi travelling in dir may pass, using
+// the public key of the peer it belongs to to match PublicKey rules.
+// Packets whose ports are unknown are dropped if ports matter.
+func (f *firewall) allow(pi packetInfo, dir FirewallDirection, peer []byte) bool {
+	if pi.Opaque && (f.ports || f.dflt == FirewallDrop) {
+		return false
+	}
+	for i := range f.rules {
+		if f.match(i, pi, dir, peer) {
+			return f.rules[i].Action == FirewallAccept
+		}
+	}
+	return f.dflt == FirewallAccept
+}
+
+func (f *firewall) match(i int, pi packetInfo, dir FirewallDirection, peer []byte) bool {
+	r := &f.rules[i]
+	if r.Direction != 0 && r.Direction != dir {
+		return false
+	}
+	if protos := f.protos[i]; protos != nil && !slices.Contains(protos, pi.Proto) {
+		return false
+	}
+	if !matchPrefixes(r.Src, pi.Src) || !matchPrefixes(r.Dst, pi.Dst) {
+		return false
+	}
+	if len(r.SrcPorts)+len(r.DstPorts) > 0 {
+		if pi.Proto != protoTCP && pi.Proto != protoUDP {
+			return false
+		}
+		if !matchPorts(r.SrcPorts, pi.SPort) || !matchPorts(r.DstPorts, pi.DPort) {
+			return false
+		}
+	}
+	if len(r.PublicKey) > 0 {
+		if !bytes.Equal(peer, r.PublicKey) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPrefixes(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return len(prefixes) == 0 || slices.ContainsFunc(prefixes, func(pf netip.Prefix) bool {
+		return pf.Contains(addr)
+	})
+}
+
+func matchPorts(ranges []PortRange, port uint16) bool {
+	return len(ranges) == 0 || slices.ContainsFunc(ranges, func(pr PortRange) bool {
+		return pr.contains(port)
+	})
+}
+
+// SetFirewall filters tunnel traffic using fw, or removes filtering if fw
+// is nil. Rules with a PublicKey use the AllowedIPs of the running device,
+// which are refreshed once per second. The firewall stays in effect across
+// Close and Open. Packet captures include packets dropped by the firewall.
+func (wgnet *WgNet) SetFirewall(fw *Firewall) (err error) {
+	var f *firewall
+	if fw != nil {
+		f, err = newFirewall(fw)
+	}
+	if err == nil {
+		wgnet.fw.Store(f)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package wgnet_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+func TestWgNet_SetFirewall(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+	for _, port := range []string{"22", "80"} {
+		l, err := srv.Listen("tcp", "10.131.132.1:"+port)
+		maybeFatal(t, err)
+		defer l.Close()
+	}
+
+	if err := srv.SetFirewall(&wgnet.Firewall{Rules: []wgnet.FirewallRule{{Action: wgnet.FirewallDrop, Protocol: "sctp"}}}); !errors.Is(err, wgnet.ErrInvalidFirewallRule) {
+		t.Error(err)
+	}
+	maybeFatal(t, srv.SetFirewall(&wgnet.Firewall{
+		Rules: []wgnet.FirewallRule{
+			{
+				Action:    wgnet.FirewallDrop,
+				Direction: wgnet.FirewallInbound,
+				Protocol:  "tcp",
+				DstPorts:  []wgnet.PortRange{{From: 22, To: 22}},
+			},
+			{
+				Action:    wgnet.FirewallDrop,
+				Protocol:  "icmp",
+				PublicKey: decodeKey("kTUQWHx4Y3ZYMZQPnRarzlx0qnen3plDoI0z7s45in4="),
+			},
+			{
+				Action: wgnet.FirewallAccept,
+				Src:    []netip.Prefix{netip.MustParsePrefix("10.131.132.0/24")},
+			},
+		},
+		Default: wgnet.FirewallDrop,
+	}))
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	c, err := cli.DialContext(ctx, "tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	maybeFatal(t, c.Close())
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, time.Millisecond*500)
+	defer shortCancel()
+	if c, err = cli.DialContext(shortCtx, "tcp", "10.131.132.1:22"); err == nil {
+		_ = c.Close()
+		t.Error("dial to dropped port succeeded")
+	}
+	pingCtx, pingCancel := context.WithTimeout(ctx, time.Millisecond*500)
+	defer pingCancel()
+	if _, err = cli.Ping4(pingCtx, "10.131.132.1"); err == nil {
+		t.Error("ping from dropped peer succeeded")
+	}
+
+	maybeFatal(t, srv.SetFirewall(nil))
+	_, err = cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+}
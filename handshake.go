@@ -0,0 +1,140 @@
+package wgnet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/linkdata/deadlock"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+var (
+	ErrHandshakeTimeout    = errors.New("handshake timeout")
+	ErrEndpointUnreachable = errors.New("endpoint unreachable")
+	ErrWrongKey            = errors.New("wrong key")
+)
+
+// OpenOptions control how OpenContext opens a WgNet.
+type OpenOptions struct {
+	Bind          conn.Bind // outer transport, default UDP bind if nil
+	WaitHandshake bool      // wait until the first handshake with the peer completes
+}
+
+// handshakePollInterval is how often OpenContext checks for a handshake.
+const handshakePollInterval = time.Millisecond * 100
+
+// handshakeLogErrors maps device log messages to the handshake problem they
+// indicate. The messages are not a stable wireguard-go API, so classifying
+// is best-effort and falls back to ErrHandshakeTimeout.
+var handshakeLogErrors = []struct {
+	msg string
+	err error
+}{
+	{"Failed to send handshake initiation", ErrEndpointUnreachable},
+	{"Received invalid response message", ErrWrongKey},
+	{"Received packet with invalid mac1", ErrWrongKey},
+}
+
+// handshakeWatch classifies handshake problems from the device log.
+type handshakeWatch struct {
+	mu  deadlock.Mutex
+	err error
+}
+
+func (hw *handshakeWatch) logf(format string) {
+	for _, hle := range handshakeLogErrors {
+		if strings.Contains(format, hle.msg) {
+			hw.mu.Lock()
+			hw.err = hle.err
+			hw.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (hw *handshakeWatch) result() (err error) {
+	hw.mu.Lock()
+	err = hw.err
+	hw.mu.Unlock()
+	if err == nil {
+		err = ErrHandshakeTimeout
+	}
+	return
+}
+
+// logger returns a device logger for level that also feeds hw.
+func (hw *handshakeWatch) logger(level int) *device.Logger {
+	logger := device.NewLogger(level, "wgnet")
+	verbosef, errorf := logger.Verbosef, logger.Errorf
+	logger.Verbosef = func(format string, args ...any) {
+		hw.logf(format)
+		verbosef(format, args...)
+	}
+	logger.Errorf = func(format string, args ...any) {
+		hw.logf(format)
+		errorf(format, args...)
+	}
+	return logger
+}
+
+// OpenContext opens the WireGuard device like OpenBind. If opts.WaitHandshake
+// is set, it then waits for the first handshake with the peer to complete,
+// prompting one if the peer has an Endpoint. If ctx is done first, the WgNet
+// is closed and the error wraps one of ErrEndpointUnreachable, ErrWrongKey or
+// ErrHandshakeTimeout. The cause is told from device log messages, so it is
+// best-effort and may degrade to ErrHandshakeTimeout with newer wireguard-go.
+// A nil opts opens using the default UDP bind without waiting.
+func (wgnet *WgNet) OpenContext(ctx context.Context, opts *OpenOptions) (err error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	bind := opts.Bind
+	if bind == nil {
+		bind = conn.NewDefaultBind()
+	}
+	if err = ctx.Err(); err == nil {
+		if err = wgnet.OpenBind(bind); err == nil && opts.WaitHandshake {
+			if err = wgnet.waitHandshake(ctx); err != nil {
+				_ = wgnet.Close()
+			}
+		}
+	}
+	return
+}
+
+func (wgnet *WgNet) waitHandshake(ctx context.Context) (err error) {
+	wgnet.mu.Lock()
+	hw := wgnet.hw
+	wgnet.mu.Unlock()
+	if wgnet.cfg.Endpoint.IsValid() && wgnet.cfg.PersistentKeepalive == 0 {
+		// turning on keepalive sends one right away, which starts a handshake
+		if err = wgnet.IpcSet(fmt.Sprintf("public_key=%x\nupdate_only=true\npersistent_keepalive_interval=25\n", wgnet.cfg.PublicKey)); err == nil {
+			defer func() {
+				_ = wgnet.IpcSet(fmt.Sprintf("public_key=%x\nupdate_only=true\npersistent_keepalive_interval=%d\n", wgnet.cfg.PublicKey, wgnet.cfg.PersistentKeepalive))
+			}()
+		}
+	}
+	ticker := time.NewTicker(handshakePollInterval)
+	defer ticker.Stop()
+	for err == nil {
+		var st *Status
+		if st, err = wgnet.Status(); err == nil {
+			for _, ps := range st.Peers {
+				if bytes.Equal(ps.PublicKey, wgnet.cfg.PublicKey) && !ps.LastHandshake.IsZero() {
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				err = errors.Join(hw.result(), ctx.Err())
+			case <-ticker.C:
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package wgnet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHandshakeWatch_Classify(t *testing.T) {
+	tests := []struct {
+		name string
+		logf func(logf func(string, ...any))
+		want error
+	}{
+		{"none", func(func(string, ...any)) {}, ErrHandshakeTimeout},
+		{"unrelated", func(logf func(string, ...any)) {
+			logf("%v - Sending handshake initiation", "peer(abcd)")
+		}, ErrHandshakeTimeout},
+		{"unreachable", func(logf func(string, ...any)) {
+			logf("%v - Failed to send handshake initiation: %v", "peer(abcd)", errors.New("network is unreachable"))
+		}, ErrEndpointUnreachable},
+		{"wrong key response", func(logf func(string, ...any)) {
+			logf("Received invalid response message from %v", "127.0.0.1:1")
+		}, ErrWrongKey},
+		{"wrong key mac1", func(logf func(string, ...any)) {
+			logf("Received packet with invalid mac1")
+		}, ErrWrongKey},
+		{"latest wins", func(logf func(string, ...any)) {
+			logf("%v - Failed to send handshake initiation: %v", "peer(abcd)", errors.New("network is unreachable"))
+			logf("Received packet with invalid mac1")
+		}, ErrWrongKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, level := range []string{"verbose", "error"} {
+				var hw handshakeWatch
+				logger := hw.logger(LogLevelSilent)
+				logf := logger.Verbosef
+				if level == "error" {
+					logf = logger.Errorf
+				}
+				tt.logf(logf)
+				if err := hw.result(); !errors.Is(err, tt.want) {
+					t.Errorf("%s: got %v, want %v", level, err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package wgnet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// unreachableBind fails every send, like a host with no route to the peer.
+type unreachableBind struct {
+	conn.Bind
+}
+
+func (unreachableBind) Send([][]byte, conn.Endpoint) error {
+	return errors.New("no route to host")
+}
+
+func TestWgNet_OpenContext_WaitHandshake(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	cli := wgnet.New(cliCfg)
+	maybeFatal(t, cli.OpenContext(ctx, &wgnet.OpenOptions{WaitHandshake: true}))
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	st, err := cli.Status()
+	maybeFatal(t, err)
+	if len(st.Peers) != 1 || st.Peers[0].LastHandshake.IsZero() {
+		t.Fatal(st.Peers)
+	}
+	if st.Peers[0].PersistentKeepalive != 0 {
+		t.Error("keepalive not restored", st.Peers[0].PersistentKeepalive)
+	}
+}
+
+func TestWgNet_OpenContext_Errors(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srvCfg.PresharedKey = decodeKey("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		bind     conn.Bind
+		want     error
+	}{
+		{"timeout", "127.0.0.1:9", nil, wgnet.ErrHandshakeTimeout},
+		{"unreachable", fmt.Sprintf("127.0.0.1:%d", listenPort), unreachableBind{conn.NewDefaultBind()}, wgnet.ErrEndpointUnreachable},
+		{"wrongkey", fmt.Sprintf("127.0.0.1:%d", listenPort), nil, wgnet.ErrWrongKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliCfg, err := wgnet.Parse(strings.NewReader(strings.Replace(clientConfig, "127.0.0.1:%d", tt.endpoint, 1)), nil)
+			maybeFatal(t, err)
+			cliCfg.PresharedKey = make([]byte, 32)
+			cli := wgnet.New(cliCfg)
+			ctx, cancel := context.WithTimeout(t.Context(), time.Second*2)
+			defer cancel()
+			err = cli.OpenContext(ctx, &wgnet.OpenOptions{Bind: tt.bind, WaitHandshake: true})
+			if !errors.Is(err, tt.want) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if _, err = cli.Status(); err == nil {
+				t.Error("expected closed WgNet")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package wgnet
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// NewLazy creates a WgNet instance from cfg that opens itself using the
+// default UDP bind when first used by DialContext, LookupHost, Ping4,
+// Listen or ListenPacket, and closes itself again once it has had no open
+// connections, listeners or lookups for idleTimeout. It may be opened and
+// closed explicitly as well, but will reopen on the next use after Close.
+// cfg must be non-nil, and idleTimeout must be positive.
+func NewLazy(cfg *Config, idleTimeout time.Duration) *WgNet {
+	return &WgNet{cfg: cfg, idle: idleTimeout}
+}
+
+// idlePoll closes a lazy WgNet that has been unused for its idle timeout.
+func (wgnet *WgNet) idlePoll(now time.Time) {
+	if wgnet.idle > 0 {
+		var dev *device.Device
+		wgnet.mu.Lock()
+		if wgnet.active == 0 && now.Sub(wgnet.used) >= wgnet.idle {
+			dev = wgnet.detach()
+		}
+		wgnet.mu.Unlock()
+		if dev != nil {
+			wgnet.close(dev)
+			wgnet.emit(Event{Kind: EventClosed})
+		}
+	}
+}
+
+// acquire counts a new connection or listener as active, returning true
+// if it must be tracked, and the Observer to notify for connections.
+func (wgnet *WgNet) acquire(isConn bool) (tracked bool, obs Observer) {
+	wgnet.mu.Lock()
+	if isConn {
+		obs = wgnet.obs
+	}
+	if tracked = wgnet.idle > 0 || obs != nil; tracked {
+		wgnet.active++
+	}
+	wgnet.mu.Unlock()
+	if obs != nil {
+		obs.ObserveConns(1)
+	}
+	return
+}
+
+func (wgnet *WgNet) release(obs Observer) {
+	wgnet.mu.Lock()
+	wgnet.active--
+	wgnet.used = time.Now()
+	wgnet.mu.Unlock()
+	if obs != nil {
+		obs.ObserveConns(-1)
+	}
+}
+
+func (wgnet *WgNet) trackConn(c net.Conn) net.Conn {
+	tracked, obs := wgnet.acquire(true)
+	flow, limited := wgnet.limitConn(c)
+	if tracked || limited {
+		c = &trackedConn{Conn: c, wgnet: wgnet, obs: obs, tracked: tracked, flow: flow, limited: limited}
+	}
+	return c
+}
+
+func (wgnet *WgNet) trackPacketConn(pc net.PacketConn) net.PacketConn {
+	if tracked, obs := wgnet.acquire(true); tracked {
+		pc = &trackedPacketConn{PacketConn: pc, wgnet: wgnet, obs: obs}
+	}
+	return pc
+}
+
+// trackListener wraps l so that accepted connections are tracked.
+func (wgnet *WgNet) trackListener(l net.Listener) net.Listener {
+	tracked, _ := wgnet.acquire(false)
+	return &trackedListener{Listener: l, wgnet: wgnet, tracked: tracked}
+}
+
+type trackedConn struct {
+	net.Conn
+	wgnet   *WgNet
+	obs     Observer
+	tracked bool // counted as active
+	flow    flowKey
+	limited bool // flow has a rate limit
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() (err error) {
+	err = c.Conn.Close()
+	c.once.Do(c.release)
+	return
+}
+
+func (c *trackedConn) release() {
+	if c.tracked {
+		c.wgnet.release(c.obs)
+	}
+	if c.limited {
+		c.wgnet.unlimitConn(c.flow)
+	}
+}
+
+type trackedPacketConn struct {
+	net.PacketConn
+	wgnet *WgNet
+	obs   Observer
+	once  sync.Once
+}
+
+func (pc *trackedPacketConn) Close() (err error) {
+	err = pc.PacketConn.Close()
+	pc.once.Do(func() { pc.wgnet.release(pc.obs) })
+	return
+}
+
+type trackedListener struct {
+	net.Listener
+	wgnet   *WgNet
+	tracked bool // the listener itself counts as active
+	once    sync.Once
+}
+
+func (l *trackedListener) Accept() (c net.Conn, err error) {
+	if c, err = l.Listener.Accept(); err == nil {
+		c = l.wgnet.trackConn(c)
+	}
+	return
+}
+
+func (l *trackedListener) Close() (err error) {
+	err = l.Listener.Close()
+	if l.tracked {
+		l.once.Do(func() { l.wgnet.release(nil) })
+	}
+	return
+}
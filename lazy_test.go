@@ -0,0 +1,70 @@
+package wgnet_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+func TestWgNet_Lazy(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+	l, err := srv.Listen("tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = c.Write([]byte("hello"))
+			_ = c.Close()
+		}
+	}()
+
+	cli := wgnet.NewLazy(cliCfg, time.Second)
+	defer cli.Close()
+	events, unsubscribe := eventChannel(cli)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	c, err := cli.DialContext(ctx, "tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	waitForEvent(t, events, wgnet.EventOpened)
+	buf := make([]byte, 5)
+	_, err = c.Read(buf)
+	maybeFatal(t, err)
+	if string(buf) != "hello" {
+		t.Error(string(buf))
+	}
+
+	// an open connection keeps the device open
+	time.Sleep(time.Second * 3)
+	if _, err = cli.Status(); err != nil {
+		t.Fatal(err)
+	}
+	maybeFatal(t, c.Close())
+	maybeFatal(t, c.Close())
+	waitForEvent(t, events, wgnet.EventClosed)
+
+	// use after idle close opens it again
+	c, err = cli.DialContext(ctx, "tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	waitForEvent(t, events, wgnet.EventOpened)
+	maybeFatal(t, c.Close())
+}
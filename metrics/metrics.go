@@ -0,0 +1,260 @@
+// Package metrics exports measurements of wgnet.WgNet instances in the
+// Prometheus text exposition format, without depending on the Prometheus
+// client library.
+package metrics
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linkdata/deadlock"
+	"github.com/linkdata/wgnet"
+)
+
+// DefaultBuckets are the histogram upper bounds in seconds used for DNS
+// lookup latency and ping round trip times.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector gathers the measurements of one WgNet.
+// It implements wgnet.Observer and http.Handler.
+type Collector struct {
+	name   string
+	wg     *wgnet.WgNet
+	mu     deadlock.Mutex // protects following
+	dialOK uint64
+	dialNG uint64
+	conns  int64
+	lookup histogram
+	ping   histogram
+}
+
+var _ wgnet.Observer = (*Collector)(nil)
+
+// New creates a Collector for wg and sets it as the Observer of wg.
+// Samples are labelled with wgnet="name".
+func New(name string, wg *wgnet.WgNet) (c *Collector) {
+	c = &Collector{
+		name:   name,
+		wg:     wg,
+		lookup: newHistogram(DefaultBuckets),
+		ping:   newHistogram(DefaultBuckets),
+	}
+	wg.SetObserver(c)
+	return
+}
+
+// ObserveDial implements wgnet.Observer.
+func (c *Collector) ObserveDial(network string, elapsed time.Duration, err error) {
+	c.mu.Lock()
+	if err == nil {
+		c.dialOK++
+	} else {
+		c.dialNG++
+	}
+	c.mu.Unlock()
+}
+
+// ObserveLookup implements wgnet.Observer.
+func (c *Collector) ObserveLookup(host string, elapsed time.Duration, err error) {
+	c.mu.Lock()
+	c.lookup.observe(elapsed.Seconds())
+	c.mu.Unlock()
+}
+
+// ObservePing implements wgnet.Observer. Failed pings are not recorded.
+func (c *Collector) ObservePing(rtt time.Duration, err error) {
+	if err == nil {
+		c.mu.Lock()
+		c.ping.observe(rtt.Seconds())
+		c.mu.Unlock()
+	}
+}
+
+// ObserveConns implements wgnet.Observer.
+func (c *Collector) ObserveConns(delta int) {
+	c.mu.Lock()
+	c.conns += int64(delta)
+	c.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics of c.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Handler(c).ServeHTTP(w, r)
+}
+
+// Handler returns an http.Handler that writes the metrics of all the
+// collectors, for use as a Prometheus scrape target.
+func Handler(collectors ...*Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = Write(w, collectors...)
+	})
+}
+
+// snapshot is a consistent copy of the measurements of a Collector.
+type snapshot struct {
+	*Collector
+	dialOK, dialNG uint64
+	conns          int64
+	lookup, ping   histogram
+	status         *wgnet.Status // nil if the WgNet is closed
+}
+
+func (c *Collector) snapshot() (s snapshot) {
+	s.Collector = c
+	s.status, _ = c.wg.Status()
+	c.mu.Lock()
+	s.dialOK, s.dialNG, s.conns = c.dialOK, c.dialNG, c.conns
+	s.lookup, s.ping = c.lookup.clone(), c.ping.clone()
+	c.mu.Unlock()
+	return
+}
+
+// Write writes the metrics of all the collectors to w in the Prometheus
+// text exposition format.
+func Write(w io.Writer, collectors ...*Collector) (err error) {
+	snaps := make([]snapshot, len(collectors))
+	for i, c := range collectors {
+		snaps[i] = c.snapshot()
+	}
+	now := time.Now()
+	var buf strings.Builder
+	family(&buf, "wgnet_up", "gauge", "Whether the WgNet is open.")
+	for _, s := range snaps {
+		up := 0
+		if s.status != nil {
+			up = 1
+		}
+		sample(&buf, "wgnet_up", s.labels(), float64(up))
+	}
+	family(&buf, "wgnet_peer_receive_bytes_total", "counter", "Bytes received from the peer.")
+	for _, s := range snaps {
+		for _, ps := range s.peers() {
+			sample(&buf, "wgnet_peer_receive_bytes_total", s.peerLabels(ps), float64(ps.RxBytes))
+		}
+	}
+	family(&buf, "wgnet_peer_transmit_bytes_total", "counter", "Bytes sent to the peer.")
+	for _, s := range snaps {
+		for _, ps := range s.peers() {
+			sample(&buf, "wgnet_peer_transmit_bytes_total", s.peerLabels(ps), float64(ps.TxBytes))
+		}
+	}
+	family(&buf, "wgnet_peer_handshake_age_seconds", "gauge", "Time since the last handshake with the peer.")
+	for _, s := range snaps {
+		for _, ps := range s.peers() {
+			if !ps.LastHandshake.IsZero() {
+				sample(&buf, "wgnet_peer_handshake_age_seconds", s.peerLabels(ps), now.Sub(ps.LastHandshake).Seconds())
+			}
+		}
+	}
+	family(&buf, "wgnet_open_connections", "gauge", "Open connections through the tunnel.")
+	for _, s := range snaps {
+		sample(&buf, "wgnet_open_connections", s.labels(), float64(s.conns))
+	}
+	family(&buf, "wgnet_dials_total", "counter", "Dials through the tunnel by result.")
+	for _, s := range snaps {
+		sample(&buf, "wgnet_dials_total", s.labels("result", "success"), float64(s.dialOK))
+		sample(&buf, "wgnet_dials_total", s.labels("result", "failure"), float64(s.dialNG))
+	}
+	family(&buf, "wgnet_dns_lookup_duration_seconds", "histogram", "DNS lookup latency.")
+	for _, s := range snaps {
+		s.lookup.write(&buf, "wgnet_dns_lookup_duration_seconds", s.labels())
+	}
+	family(&buf, "wgnet_ping_rtt_seconds", "histogram", "Ping round trip time.")
+	for _, s := range snaps {
+		s.ping.write(&buf, "wgnet_ping_rtt_seconds", s.labels())
+	}
+	_, err = io.WriteString(w, buf.String())
+	return
+}
+
+func (s snapshot) peers() (peers []wgnet.PeerStatus) {
+	if s.status != nil {
+		peers = s.status.Peers
+	}
+	return
+}
+
+// labels returns the label set of s with the extra name and value pairs in kv.
+func (s snapshot) labels(kv ...string) string {
+	var buf strings.Builder
+	buf.WriteString(`wgnet="`)
+	buf.WriteString(escape(s.name))
+	buf.WriteByte('"')
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&buf, `,%s="%s"`, kv[i], escape(kv[i+1]))
+	}
+	return buf.String()
+}
+
+func (s snapshot) peerLabels(ps wgnet.PeerStatus) string {
+	return s.labels("peer", base64.StdEncoding.EncodeToString(ps.PublicKey))
+}
+
+func family(buf *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sample(buf *strings.Builder, name, labels string, value float64) {
+	fmt.Fprintf(buf, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative; the last is for +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h histogram) clone() histogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+func (h histogram) write(buf *strings.Builder, name, labels string) {
+	var cum uint64
+	for i, n := range h.counts {
+		cum += n
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i]
+		}
+		sample(buf, name+"_bucket", labels+`,le="`+formatFloat(le)+`"`, float64(cum))
+	}
+	sample(buf, name+"_sum", labels, h.sum)
+	sample(buf, name+"_count", labels, float64(h.count))
+}
@@ -0,0 +1,121 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	mrand "math/rand/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"github.com/linkdata/wgnet/metrics"
+)
+
+var serverConfig = `[Interface]
+PrivateKey = GInruesHOogIjjFsKCorYEAENAfYfPL/yH8ObFgyFUs=
+ListenPort = %d
+Address = 10.131.132.1/24
+
+[Peer]
+PublicKey = kTUQWHx4Y3ZYMZQPnRarzlx0qnen3plDoI0z7s45in4=
+AllowedIPs = 10.131.132.2/32
+`
+
+var clientConfig = `[Interface]
+PrivateKey = AEnvL9tVr+7JF0sMVjjzPjIxrrc/hoVJ5B82WWpVamI=
+Address = 10.131.132.2/24
+
+[Peer]
+PublicKey = Wh3yY7/fE3fyHJ8TOwLJ//CIRbgrlVl4bLQ+npNBSRU=
+Endpoint = 127.0.0.1:%d
+AllowedIPs = 0.0.0.0/0, ::/0
+
+[Hosts]
+srv.test = 10.131.132.1
+`
+
+func maybeFatal(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeNets(t *testing.T) (srv, cli *wgnet.WgNet) {
+	listenPort := 20000 + mrand.IntN(10000)
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv, cli = wgnet.New(srvCfg), wgnet.New(cliCfg)
+	maybeFatal(t, srv.Open())
+	t.Cleanup(func() { _ = srv.Close() })
+	maybeFatal(t, cli.Open())
+	t.Cleanup(func() { _ = cli.Close() })
+	return
+}
+
+func scrape(t *testing.T, c *metrics.Collector) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Error(ct)
+	}
+	return rec.Body.String()
+}
+
+func TestCollector(t *testing.T) {
+	srv, cli := makeNets(t)
+	l, err := srv.Listen("tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	defer l.Close()
+
+	c := metrics.New("cli", cli)
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	_, err = cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	_, err = cli.LookupHost(ctx, "srv.test")
+	maybeFatal(t, err)
+	conn, err := cli.DialContext(ctx, "tcp", "srv.test:80")
+	maybeFatal(t, err)
+	if _, err = cli.DialContext(ctx, "tcp", "srv.test:81"); err == nil {
+		t.Error("expected error")
+	}
+
+	text := scrape(t, c)
+	for _, want := range []string{
+		"# TYPE wgnet_peer_receive_bytes_total counter\n",
+		`wgnet_up{wgnet="cli"} 1`,
+		`wgnet_peer_transmit_bytes_total{wgnet="cli",peer="Wh3yY7/fE3fyHJ8TOwLJ//CIRbgrlVl4bLQ+npNBSRU="} `,
+		`wgnet_peer_handshake_age_seconds{wgnet="cli",peer="Wh3yY7/fE3fyHJ8TOwLJ//CIRbgrlVl4bLQ+npNBSRU="} `,
+		`wgnet_open_connections{wgnet="cli"} 1`,
+		`wgnet_dials_total{wgnet="cli",result="success"} 1`,
+		`wgnet_dials_total{wgnet="cli",result="failure"} 1`,
+		`wgnet_dns_lookup_duration_seconds_count{wgnet="cli"} 1`,
+		`wgnet_dns_lookup_duration_seconds_bucket{wgnet="cli",le="+Inf"} 1`,
+		`wgnet_ping_rtt_seconds_count{wgnet="cli"} 1`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in\n%s", want, text)
+		}
+	}
+
+	maybeFatal(t, conn.Close())
+	maybeFatal(t, cli.Close())
+	text = scrape(t, c)
+	for _, want := range []string{
+		`wgnet_up{wgnet="cli"} 0`,
+		`wgnet_open_connections{wgnet="cli"} 0`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "wgnet_peer_receive_bytes_total{") {
+		t.Errorf("unexpected peer metrics for closed WgNet\n%s", text)
+	}
+}
@@ -0,0 +1,38 @@
+package wgnet
+
+import "time"
+
+// Observer receives measurements from a WgNet, for example to export them
+// as metrics. Its methods are called synchronously and must not block.
+type Observer interface {
+	// ObserveDial is called when DialContext or a Dialer completes a dial.
+	ObserveDial(network string, elapsed time.Duration, err error)
+	// ObserveLookup is called when LookupHost completes.
+	ObserveLookup(host string, elapsed time.Duration, err error)
+	// ObservePing is called when Ping4 completes.
+	ObservePing(rtt time.Duration, err error)
+	// ObserveConns is called with +1 when a connection is opened and
+	// -1 when it is closed, counting both dialed and accepted connections.
+	ObserveConns(delta int)
+}
+
+// SetObserver sets the Observer that receives measurements, or removes it
+// if obs is nil. Only connections opened after the call are counted.
+func (wgnet *WgNet) SetObserver(obs Observer) {
+	wgnet.mu.Lock()
+	wgnet.obs = obs
+	wgnet.mu.Unlock()
+}
+
+func (wgnet *WgNet) observer() (obs Observer) {
+	wgnet.mu.Lock()
+	obs = wgnet.obs
+	wgnet.mu.Unlock()
+	return
+}
+
+func (wgnet *WgNet) observeDial(network string, start time.Time, err error) {
+	if obs := wgnet.observer(); obs != nil {
+		obs.ObserveDial(network, time.Since(start), err)
+	}
+}
@@ -17,4 +17,7 @@ type Options struct {
 	DNS        string
 	LogLevel   int
 	AllowIpv6  bool
+	ExpandEnv  bool              // expand ${VAR} in values from the environment
+	KeyFiles   bool              // allow [Interface] PrivateKeyFile and [Peer] PresharedKeyFile
+	Hosts      map[string]string // default [Hosts] entries, name to comma separated addresses
 }
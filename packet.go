@@ -0,0 +1,255 @@
+package wgnet
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// tunMTU is the MTU of the netstack, and so the largest packet size.
+const tunMTU = 1420
+
+// IP protocol numbers.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// IPv6 extension header numbers.
+const (
+	ext6HopByHop = 0
+	ext6Routing  = 43
+	ext6Fragment = 44
+	ext6AH       = 51
+	ext6DstOpts  = 60
+)
+
+// packetInfo holds the header fields of an IP packet used for matching.
+type packetInfo struct {
+	Src, Dst     netip.Addr
+	Proto        uint8
+	SPort, DPort uint16 // zero unless Proto is TCP or UDP
+	Opaque       bool   // the TCP or UDP header is missing, so the ports are unknown
+}
+
+// remote returns the address of the peer side of a packet travelling in dir.
+func (pi packetInfo) remote(dir FirewallDirection) netip.Addr {
+	if dir == FirewallOutbound {
+		return pi.Dst
+	}
+	return pi.Src
+}
+
+// parsePacket returns the header fields of the IPv4 or IPv6 packet pkt.
+// IPv6 extension headers are followed, so Proto is the upper layer protocol.
+func parsePacket(pkt []byte) (pi packetInfo, ok bool) {
+	var hlen int
+	if len(pkt) > 0 {
+		switch pkt[0] >> 4 {
+		case 4:
+			if hlen = int(pkt[0]&0x0f) * 4; hlen >= 20 && len(pkt) >= hlen {
+				pi.Src = netip.AddrFrom4([4]byte(pkt[12:16]))
+				pi.Dst = netip.AddrFrom4([4]byte(pkt[16:20]))
+				pi.Proto = pkt[9]
+				if binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+					hlen = 0 // not the first fragment, so no transport header
+				}
+				ok = true
+			}
+		case 6:
+			if hlen = 40; len(pkt) >= hlen {
+				pi.Src = netip.AddrFrom16([16]byte(pkt[8:24]))
+				pi.Dst = netip.AddrFrom16([16]byte(pkt[24:40]))
+				pi.Proto = pkt[6]
+				hlen = skipExt6(pkt, &pi)
+				ok = true
+			}
+		}
+	}
+	if ok && (pi.Proto == protoTCP || pi.Proto == protoUDP) {
+		if pi.Opaque = hlen == 0 || len(pkt) < hlen+4; !pi.Opaque {
+			pi.SPort = binary.BigEndian.Uint16(pkt[hlen:])
+			pi.DPort = binary.BigEndian.Uint16(pkt[hlen+2:])
+		}
+	}
+	return
+}
+
+// skipExt6 follows the extension headers of the IPv6 packet pkt, setting
+// pi.Proto to the upper layer protocol. It returns the offset of the upper
+// layer header, or zero if it is not in pkt, marking pi.Opaque if the chain
+// is truncated.
+func skipExt6(pkt []byte, pi *packetInfo) (hlen int) {
+	hlen = 40
+	for hlen > 0 {
+		var extlen int
+		switch pi.Proto {
+		case ext6HopByHop, ext6Routing, ext6DstOpts:
+			if len(pkt) >= hlen+2 {
+				extlen = (int(pkt[hlen+1]) + 1) * 8
+			}
+		case ext6AH:
+			if len(pkt) >= hlen+2 {
+				extlen = (int(pkt[hlen+1]) + 2) * 4
+			}
+		case ext6Fragment:
+			if extlen = 8; len(pkt) >= hlen+extlen && binary.BigEndian.Uint16(pkt[hlen+2:])>>3 != 0 {
+				pi.Proto = pkt[hlen]
+				return 0 // not the first fragment, so no upper layer header
+			}
+		default:
+			return
+		}
+		if extlen == 0 || len(pkt) < hlen+extlen {
+			pi.Opaque = true
+			return 0
+		}
+		pi.Proto = pkt[hlen]
+		hlen += extlen
+	}
+	return
+}
+
+// peersPoll records the peers of the device for matching packets to peers,
+// and forgets the rate limits of removed peers.
+func (wgnet *WgNet) peersPoll(st *Status) {
+	pt := newPeerTable(st.Peers)
+	wgnet.peers.Store(pt)
+	if rl := wgnet.rl.Load(); rl != nil {
+		rl.prunePeers(pt)
+	}
+}
+
+// peerTable maps the AllowedIPs prefixes of the peers to their public keys.
+type peerTable struct {
+	keys map[netip.Prefix][]byte
+	bits [2][]int // distinct prefix lengths for IPv4 and IPv6, longest first
+}
+
+func newPeerTable(peers []PeerStatus) (pt *peerTable) {
+	pt = &peerTable{keys: map[netip.Prefix][]byte{}}
+	for _, ps := range peers {
+		for _, pf := range ps.AllowedIPs {
+			pf = pf.Masked()
+			pt.keys[pf] = ps.PublicKey
+			if fam := family(pf.Addr()); !slices.Contains(pt.bits[fam], pf.Bits()) {
+				pt.bits[fam] = append(pt.bits[fam], pf.Bits())
+			}
+		}
+	}
+	for _, bits := range pt.bits {
+		slices.SortFunc(bits, func(a, b int) int { return b - a })
+	}
+	return
+}
+
+func family(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// peerKey returns the public key of the peer with the most specific
+// AllowedIPs prefix containing addr, as WireGuard routes packets.
+func (pt *peerTable) peerKey(addr netip.Addr) (key []byte) {
+	if pt != nil {
+		for _, bits := range pt.bits[family(addr)] {
+			if pf, err := addr.Prefix(bits); err == nil {
+				if key = pt.keys[pf]; key != nil {
+					break
+				}
+			}
+		}
+	}
+	return
+}
+
+// has returns true if a peer has the public key key.
+func (pt *peerTable) has(key string) bool {
+	for _, k := range pt.keys {
+		if string(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// packetTun wraps the netstack tun.Device so packets passing between the
+// netstack and the WireGuard device can be inspected.
+// Read returns packets sent by the netstack, Write delivers received packets.
+type packetTun struct {
+	tun.Device
+	wgnet *WgNet
+}
+
+// pass returns true if pkt travelling in dir passes the firewall and rate limits.
+// Packets that are not IP are dropped by the firewall but not rate limited.
+func (t *packetTun) pass(pkt []byte, dir FirewallDirection, f *firewall, rl *rateLimiter) bool {
+	pi, ok := parsePacket(pkt)
+	if !ok {
+		return f == nil
+	}
+	peer := t.wgnet.peers.Load().peerKey(pi.remote(dir))
+	return (f == nil || f.allow(pi, dir, peer)) && (rl == nil || rl.allow(pi, dir, peer, len(pkt)))
+}
+
+func (t *packetTun) Read(bufs [][]byte, sizes []int, offset int) (n int, err error) {
+	if n, err = t.Device.Read(bufs, sizes, offset); n > 0 {
+		if c := t.wgnet.capture.Load(); c != nil {
+			for i := range n {
+				c.packet(bufs[i][offset:offset+sizes[i]], false)
+			}
+		}
+		f, rl := t.wgnet.fw.Load(), t.wgnet.rl.Load()
+		if f != nil || rl != nil {
+			// the device owns the buffers, so move the data rather than the slices
+			kept := 0
+			for i := range n {
+				if t.pass(bufs[i][offset:offset+sizes[i]], FirewallOutbound, f, rl) {
+					if kept != i {
+						copy(bufs[kept][offset:], bufs[i][offset:offset+sizes[i]])
+						sizes[kept] = sizes[i]
+					}
+					kept++
+				}
+			}
+			n = kept
+		}
+	}
+	return
+}
+
+// Write delivers bufs to the netstack, reporting dropped packets as written.
+func (t *packetTun) Write(bufs [][]byte, offset int) (n int, err error) {
+	if c := t.wgnet.capture.Load(); c != nil {
+		for _, buf := range bufs {
+			c.packet(buf[offset:], true)
+		}
+	}
+	f, rl := t.wgnet.fw.Load(), t.wgnet.rl.Load()
+	if f != nil || rl != nil {
+		var kept [][]byte
+		for i, buf := range bufs {
+			if t.pass(buf[offset:], FirewallInbound, f, rl) {
+				if kept != nil {
+					kept = append(kept, buf)
+				}
+			} else if kept == nil {
+				kept = append(make([][]byte, 0, len(bufs)), bufs[:i]...)
+			}
+		}
+		if kept != nil {
+			dropped := len(bufs) - len(kept)
+			if len(kept) > 0 {
+				n, err = t.Device.Write(kept, offset)
+			}
+			return n + dropped, err
+		}
+	}
+	return t.Device.Write(bufs, offset)
+}
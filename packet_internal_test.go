@@ -0,0 +1,67 @@
+package wgnet
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// ipv6Packet returns an IPv6 packet with next header next and payload.
+func ipv6Packet(next uint8, payload []byte) (pkt []byte) {
+	pkt = make([]byte, 40, 40+len(payload))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:], uint16(len(payload))) // #nosec G115
+	pkt[6] = next
+	pkt[8], pkt[23] = 0xfd, 1
+	pkt[24], pkt[39] = 0xfd, 2
+	return append(pkt, payload...)
+}
+
+func TestParsePacket_IPv6ExtensionHeaders(t *testing.T) {
+	udp := []byte{0x12, 0x34, 0x00, 0x35, 0, 8, 0, 0}
+	hopByHop := []byte{ext6DstOpts, 0, 1, 4, 0, 0, 0, 0}
+	dstOpts := []byte{ext6Fragment, 0, 1, 4, 0, 0, 0, 0}
+	firstFragment := []byte{protoUDP, 0, 0x00, 0x01, 0, 0, 0, 1}
+	laterFragment := []byte{protoUDP, 0, 0x05, 0x01, 0, 0, 0, 1}
+
+	tests := []struct {
+		name   string
+		pkt    []byte
+		proto  uint8
+		dport  uint16
+		opaque bool
+	}{
+		{"plain", ipv6Packet(protoUDP, udp), protoUDP, 53, false},
+		{"chain", ipv6Packet(ext6HopByHop, slices.Concat(hopByHop, dstOpts, firstFragment, udp)), protoUDP, 53, false},
+		{"later fragment", ipv6Packet(ext6Fragment, slices.Concat(laterFragment, udp)), protoUDP, 0, true},
+		{"truncated chain", ipv6Packet(ext6HopByHop, hopByHop[:4]), ext6HopByHop, 0, true},
+		{"truncated upper", ipv6Packet(ext6HopByHop, slices.Concat(hopByHop, dstOpts, firstFragment)), protoUDP, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pi, ok := parsePacket(tt.pkt)
+			if !ok || pi.Proto != tt.proto || pi.DPort != tt.dport || pi.Opaque != tt.opaque {
+				t.Errorf("%v %+v", ok, pi)
+			}
+		})
+	}
+
+	// packets with unknown ports must not slip past port rules
+	f, err := newFirewall(&Firewall{Rules: []FirewallRule{{Action: FirewallDrop, Protocol: "udp", DstPorts: []PortRange{{53, 53}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := func(pkt []byte) bool {
+		pi, _ := parsePacket(pkt)
+		return f.allow(pi, FirewallInbound, nil)
+	}
+	if allow(tests[1].pkt) {
+		t.Error("port rule not applied after extension headers")
+	}
+	if allow(tests[2].pkt) {
+		t.Error("later fragment passed port rule")
+	}
+	if !allow(ipv6Packet(protoUDP, []byte{0x12, 0x34, 0x00, 0x36, 0, 8, 0, 0})) {
+		t.Error("unmatched packet dropped")
+	}
+}
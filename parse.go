@@ -6,6 +6,8 @@ import (
 	"errors"
 	"io"
 	"net/netip"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -23,10 +25,20 @@ var ErrInvalidPeerAllowedIPs = errors.New("invalid [Peer] AllowedIPs")
 var ErrInvalidPeerPresharedKey = errors.New("invalid [Peer] PresharedKey")
 var ErrInvalidPeerPersistentKeepalive = errors.New("invalid [Peer] PersistentKeepalive")
 var ErrInvalidInterfaceListenPort = errors.New("invalid [Interface] ListenPort")
+var ErrUndefinedEnvironmentVariable = errors.New("undefined environment variable")
+var ErrKeyAndKeyFile = errors.New("both key and key file given")
+var ErrInvalidHostsAddress = errors.New("invalid [Hosts] address")
+
+var envVarRx = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
 
 // Parse reads a WireGuard configuration file, validates it and returns a Config.
 // The reader must be non-nil and contain a valid WireGuard INI config.
 // If opts is nil, Parse uses DefaultOptions, which must also be non-nil.
+//
+// If opts.ExpandEnv is set, ${VAR} references in values are replaced with
+// the value of the environment variable VAR. If opts.KeyFiles is set, the
+// private and preshared keys may be read from the files named by
+// [Interface] PrivateKeyFile and [Peer] PresharedKeyFile.
 func Parse(r io.Reader, opts *Options) (cfg *Config, err error) {
 	if opts == nil {
 		opts = DefaultOptions
@@ -34,6 +46,16 @@ func Parse(r io.Reader, opts *Options) (cfg *Config, err error) {
 
 	var inif inifile.File
 	if inif, err = inifile.Parse(r, ','); err == nil {
+		if opts.ExpandEnv {
+			err = expandEnv(inif)
+		}
+		if err == nil && opts.KeyFiles {
+			if err = loadKeyFile(inif, "interface", "privatekey", ErrInvalidInterfacePrivateKey); err == nil {
+				err = loadKeyFile(inif, "peer", "presharedkey", ErrInvalidPeerPresharedKey)
+			}
+		}
+	}
+	if err == nil {
 		var cf Config
 		if cf.PrivateKey, err = mustDecode(inif, "interface", "privatekey", ErrInvalidInterfacePrivateKey); err == nil {
 			if cf.PublicKey, err = mustDecode(inif, "peer", "publickey", ErrInvalidPeerPublicKey); err == nil {
@@ -45,6 +67,8 @@ func Parse(r io.Reader, opts *Options) (cfg *Config, err error) {
 						}
 						if opts.AllowIpv6 || pf.Addr().Is4() {
 							cf.Addresses = append(cf.Addresses, pf)
+						} else {
+							cf.dropped = append(cf.dropped, pf)
 						}
 					}
 				}
@@ -53,7 +77,15 @@ func Parse(r io.Reader, opts *Options) (cfg *Config, err error) {
 				}
 
 				for addr := range strings.SplitSeq(inif.GetDefault("interface", "dns", opts.DNS), ",") {
-					if addr != "" {
+					if addr = strings.TrimSpace(addr); addr != "" {
+						if isSecureDNS(addr) {
+							cf.SecureDNS = append(cf.SecureDNS, addr)
+							continue
+						}
+						if isSearchDomain(addr) {
+							cf.SearchDomains = append(cf.SearchDomains, strings.TrimSuffix(addr, "."))
+							continue
+						}
 						var a netip.Addr
 						if a, err = mustAddress(addr, ErrInvalidInterfaceDNS); err != nil {
 							return
@@ -96,12 +128,25 @@ func Parse(r io.Reader, opts *Options) (cfg *Config, err error) {
 
 				if err == nil {
 					if v, ok := inif.Get("peer", "endpoint"); ok {
-						if cf.Endpoint, err = netip.ParseAddrPort(v); err != nil {
-							err = errors.Join(ErrInvalidPeerEndpoint, err)
+						for ep := range strings.SplitSeq(v, ",") {
+							var ap netip.AddrPort
+							if ap, err = netip.ParseAddrPort(strings.TrimSpace(ep)); err != nil {
+								err = errors.Join(ErrInvalidPeerEndpoint, err)
+								break
+							}
+							if cf.Endpoint.IsValid() {
+								cf.FallbackEndpoints = append(cf.FallbackEndpoints, ap)
+							} else {
+								cf.Endpoint = ap
+							}
 						}
 					}
 				}
 
+				if err == nil {
+					cf.Hosts, err = parseHosts(opts.Hosts, inif["hosts"])
+				}
+
 				if err == nil {
 					cf.LogLevel = opts.LogLevel
 					cfg = &cf
@@ -113,6 +158,63 @@ func Parse(r io.Reader, opts *Options) (cfg *Config, err error) {
 	return
 }
 
+// parseHosts returns the entries of dflt overridden by those of sect,
+// or nil if both are empty.
+func parseHosts(dflt map[string]string, sect inifile.Section) (hosts map[string][]netip.Addr, err error) {
+	for _, entries := range []map[string]string{dflt, sect} {
+		for name, v := range entries {
+			var addrs []netip.Addr
+			for addr := range strings.SplitSeq(v, ",") {
+				if addr != "" {
+					var a netip.Addr
+					if a, err = mustAddress(addr, ErrInvalidHostsAddress); err != nil {
+						return nil, err
+					}
+					addrs = append(addrs, a)
+				}
+			}
+			if hosts == nil {
+				hosts = make(map[string][]netip.Addr)
+			}
+			hosts[hostsKey(name)] = addrs
+		}
+	}
+	return
+}
+
+// expandEnv replaces ${VAR} references in all values of inif.
+func expandEnv(inif inifile.File) (err error) {
+	for _, sect := range inif {
+		for k, v := range sect {
+			sect[k] = envVarRx.ReplaceAllStringFunc(v, func(ref string) string {
+				name := envVarRx.FindStringSubmatch(ref)[1]
+				val, ok := os.LookupEnv(name)
+				if !ok && err == nil {
+					err = errors.Join(ErrUndefinedEnvironmentVariable, errors.New(name))
+				}
+				return val
+			})
+		}
+	}
+	return
+}
+
+// loadKeyFile sets section.key from the file named by section.keyfile.
+func loadKeyFile(inif inifile.File, section, key string, fail error) (err error) {
+	if fn, ok := inif.Get(section, key+"file"); ok {
+		if _, ok = inif.Get(section, key); ok {
+			return errors.Join(fail, ErrKeyAndKeyFile)
+		}
+		var b []byte
+		if b, err = os.ReadFile(strings.TrimSpace(fn)); err == nil {
+			inif.Set(section, key, strings.TrimSpace(string(b)), ',')
+		} else {
+			err = errors.Join(fail, err)
+		}
+	}
+	return
+}
+
 func decodeHexKey(key string) (decoded []byte, err error) {
 	if decoded, err = hex.DecodeString(key); err == nil {
 		if len(decoded) != 32 {
@@ -140,6 +242,32 @@ func decodePresharedKey(key string) (decoded []byte, err error) {
 	return
 }
 
+// isSearchDomain returns true if s is a domain name whose last label is
+// not numeric, so that mistyped IP addresses are not taken as domains.
+func isSearchDomain(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	var hasLetter bool
+	for label := range strings.SplitSeq(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		hasLetter = false
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+				hasLetter = true
+			case c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return hasLetter
+}
+
 func mustAddress(addr string, fail error) (a netip.Addr, err error) {
 	if a, err = netip.ParseAddr(strings.TrimSpace(addr)); err != nil {
 		err = errors.Join(fail, err)
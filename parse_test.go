@@ -4,6 +4,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -371,3 +373,202 @@ func TestParse_EndpointMustBeIP(t *testing.T) {
 		t.Fatalf("expected error %v, got %v", wgnet.ErrInvalidPeerEndpoint, err)
 	}
 }
+
+func TestParse_ExpandEnv(t *testing.T) {
+	t.Setenv("WGNET_TEST_PRIVATE_KEY", "WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=")
+	text := `
+		[interface]
+		privatekey = ${WGNET_TEST_PRIVATE_KEY}
+		address = 192.168.1.0/24
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+	`
+	if _, err := wgnet.Parse(strings.NewReader(text), nil); !errors.Is(err, wgnet.ErrInvalidInterfacePrivateKey) {
+		t.Fatalf("expected %v without ExpandEnv, got %v", wgnet.ErrInvalidInterfacePrivateKey, err)
+	}
+	cfg, err := wgnet.Parse(strings.NewReader(text), &wgnet.Options{ExpandEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.PrivateKey, decodeKey("WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=")) {
+		t.Fatal(cfg.PrivateKey)
+	}
+	text = strings.Replace(text, "WGNET_TEST_PRIVATE_KEY", "WGNET_TEST_UNDEFINED", 1)
+	if _, err = wgnet.Parse(strings.NewReader(text), &wgnet.Options{ExpandEnv: true}); !errors.Is(err, wgnet.ErrUndefinedEnvironmentVariable) {
+		t.Fatalf("expected %v, got %v", wgnet.ErrUndefinedEnvironmentVariable, err)
+	}
+}
+
+func TestParse_KeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	privfile := filepath.Join(dir, "private.key")
+	pskfile := filepath.Join(dir, "preshared.key")
+	if err := os.WriteFile(privfile, []byte("WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pskfile, []byte("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	text := `
+		[interface]
+		privatekeyfile = ` + privfile + `
+		address = 192.168.1.0/24
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		presharedkeyfile = ` + pskfile + `
+	`
+	if _, err := wgnet.Parse(strings.NewReader(text), nil); !errors.Is(err, wgnet.ErrInvalidInterfacePrivateKey) {
+		t.Fatalf("expected %v without KeyFiles, got %v", wgnet.ErrInvalidInterfacePrivateKey, err)
+	}
+	cfg, err := wgnet.Parse(strings.NewReader(text), &wgnet.Options{KeyFiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.PrivateKey, decodeKey("WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=")) {
+		t.Error(cfg.PrivateKey)
+	}
+	if !reflect.DeepEqual(cfg.PresharedKey, decodeKey("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")) {
+		t.Error(cfg.PresharedKey)
+	}
+
+	both := strings.Replace(text, "[peer]", "privatekey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=\n[peer]", 1)
+	if _, err = wgnet.Parse(strings.NewReader(both), &wgnet.Options{KeyFiles: true}); !errors.Is(err, wgnet.ErrKeyAndKeyFile) {
+		t.Errorf("expected %v, got %v", wgnet.ErrKeyAndKeyFile, err)
+	}
+	missing := strings.Replace(text, pskfile, filepath.Join(dir, "missing.key"), 1)
+	if _, err = wgnet.Parse(strings.NewReader(missing), &wgnet.Options{KeyFiles: true}); !errors.Is(err, wgnet.ErrInvalidPeerPresharedKey) {
+		t.Errorf("expected %v, got %v", wgnet.ErrInvalidPeerPresharedKey, err)
+	}
+}
+
+func TestParse_FallbackEndpoints(t *testing.T) {
+	text := `
+		[interface]
+		privatekey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		address = 192.168.1.0/24
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		endpoint = 10.0.0.1:51820, 10.0.0.2:51820,[fd00::1]:51820
+	`
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	if cfg.Endpoint != netip.MustParseAddrPort("10.0.0.1:51820") {
+		t.Error(cfg.Endpoint)
+	}
+	want := []netip.AddrPort{netip.MustParseAddrPort("10.0.0.2:51820"), netip.MustParseAddrPort("[fd00::1]:51820")}
+	if !reflect.DeepEqual(cfg.FallbackEndpoints, want) {
+		t.Error(cfg.FallbackEndpoints)
+	}
+	again, err := wgnet.Parse(strings.NewReader(cfg.Marshal()), nil)
+	maybeFatal(t, err)
+	if !reflect.DeepEqual(again, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", again, cfg)
+	}
+
+	text = strings.Replace(text, "10.0.0.2:51820", "localhost:51820", 1)
+	if _, err = wgnet.Parse(strings.NewReader(text), nil); !errors.Is(err, wgnet.ErrInvalidPeerEndpoint) {
+		t.Errorf("expected %v, got %v", wgnet.ErrInvalidPeerEndpoint, err)
+	}
+}
+
+func TestParse_SearchDomains(t *testing.T) {
+	text := `
+		[interface]
+		privatekey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		address = 192.168.1.0/24
+		dns = 10.0.0.1, corp.internal, lab.corp.internal.
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+	`
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	if !reflect.DeepEqual(cfg.DNS, []netip.Addr{netip.MustParseAddr("10.0.0.1")}) {
+		t.Error(cfg.DNS)
+	}
+	if !reflect.DeepEqual(cfg.SearchDomains, []string{"corp.internal", "lab.corp.internal"}) {
+		t.Error(cfg.SearchDomains)
+	}
+	if !strings.Contains(cfg.String(), "DNS = 10.0.0.1,corp.internal,lab.corp.internal") {
+		t.Error(cfg.String())
+	}
+	again, err := wgnet.Parse(strings.NewReader(cfg.Marshal()), nil)
+	maybeFatal(t, err)
+	if !reflect.DeepEqual(again, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", again, cfg)
+	}
+
+	for _, bad := range []string{"10.0.0.300", "-corp.internal", "corp..internal", "corp internal"} {
+		text := strings.Replace(text, "corp.internal,", bad+",", 1)
+		if _, err = wgnet.Parse(strings.NewReader(text), nil); !errors.Is(err, wgnet.ErrInvalidInterfaceDNS) {
+			t.Errorf("%q: expected %v, got %v", bad, wgnet.ErrInvalidInterfaceDNS, err)
+		}
+	}
+}
+
+func TestParse_Hosts(t *testing.T) {
+	text := `
+		[interface]
+		privatekey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		address = 192.168.1.0/24
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		[hosts]
+		DB.corp.internal = 10.0.0.5, 10.0.0.6
+		git = 10.0.0.7
+	`
+	opts := *wgnet.DefaultOptions
+	opts.Hosts = map[string]string{"git": "10.0.0.1", "wiki": "10.0.0.8"}
+	cfg, err := wgnet.Parse(strings.NewReader(text), &opts)
+	maybeFatal(t, err)
+	want := map[string][]netip.Addr{
+		"db.corp.internal": {netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.6")},
+		"git":              {netip.MustParseAddr("10.0.0.7")},
+		"wiki":             {netip.MustParseAddr("10.0.0.8")},
+	}
+	if !reflect.DeepEqual(cfg.Hosts, want) {
+		t.Error(cfg.Hosts)
+	}
+	if !strings.Contains(cfg.String(), "[Hosts]\ndb.corp.internal = 10.0.0.5,10.0.0.6\ngit = 10.0.0.7\nwiki = 10.0.0.8\n") {
+		t.Error(cfg.String())
+	}
+	again, err := wgnet.Parse(strings.NewReader(cfg.Marshal()), nil)
+	maybeFatal(t, err)
+	if !reflect.DeepEqual(again, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", again, cfg)
+	}
+
+	text = strings.Replace(text, "10.0.0.7", "git.corp.internal", 1)
+	if _, err = wgnet.Parse(strings.NewReader(text), nil); !errors.Is(err, wgnet.ErrInvalidHostsAddress) {
+		t.Errorf("expected %v, got %v", wgnet.ErrInvalidHostsAddress, err)
+	}
+}
+
+func TestParse_SecureDNS(t *testing.T) {
+	text := `
+		[interface]
+		privatekey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		address = 192.168.1.0/24
+		dns = 10.0.0.1, https://10.0.0.2/dns-query, tls://10.0.0.3:8853, corp.internal
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+	`
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	if !reflect.DeepEqual(cfg.SecureDNS, []string{"https://10.0.0.2/dns-query", "tls://10.0.0.3:8853"}) {
+		t.Error(cfg.SecureDNS)
+	}
+	if !strings.Contains(cfg.String(), "DNS = 10.0.0.1,https://10.0.0.2/dns-query,tls://10.0.0.3:8853,corp.internal") {
+		t.Error(cfg.String())
+	}
+	again, err := wgnet.Parse(strings.NewReader(cfg.Marshal()), nil)
+	maybeFatal(t, err)
+	if !reflect.DeepEqual(again, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", again, cfg)
+	}
+	for _, bad := range []string{"http://10.0.0.2/dns-query", "tls://10.0.0.3/path", "https://"} {
+		text := strings.Replace(text, "https://10.0.0.2/dns-query", bad, 1)
+		if _, err = wgnet.Parse(strings.NewReader(text), nil); !errors.Is(err, wgnet.ErrInvalidInterfaceDNS) {
+			t.Errorf("%q: expected %v, got %v", bad, wgnet.ErrInvalidInterfaceDNS, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package wgnet
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidUapiLine = errors.New("invalid UAPI line")
+var ErrUapiErrno = errors.New("UAPI error")
+var ErrUapiMultiplePeers = errors.New("UAPI has multiple peers")
+
+// PeerStatus holds the configuration and runtime state of a peer as
+// reported by the device.
+type PeerStatus struct {
+	PublicKey           []byte
+	PresharedKey        []byte // #nosec G117
+	Endpoint            netip.AddrPort
+	AllowedIPs          []netip.Prefix
+	PersistentKeepalive int
+	LastHandshake       time.Time
+	RxBytes             uint64
+	TxBytes             uint64
+}
+
+// Status holds the runtime state of a device as reported by the device.
+type Status struct {
+	PrivateKey []byte // #nosec G117
+	ListenPort int
+	Peers      []PeerStatus
+}
+
+// String returns the peer status with the preshared key replaced by
+// Redacted, making it safe to log.
+func (ps PeerStatus) String() string {
+	psk := ""
+	if len(ps.PresharedKey) > 0 {
+		psk = Redacted
+	}
+	return fmt.Sprintf("{PublicKey:%s PresharedKey:%s Endpoint:%s AllowedIPs:%v PersistentKeepalive:%d LastHandshake:%s RxBytes:%d TxBytes:%d}",
+		base64.StdEncoding.EncodeToString(ps.PublicKey), psk, ps.Endpoint, ps.AllowedIPs,
+		ps.PersistentKeepalive, ps.LastHandshake.Format(time.RFC3339), ps.RxBytes, ps.TxBytes)
+}
+
+// Format implements fmt.Formatter so that all verbs print the redacted
+// form returned by String.
+func (ps PeerStatus) Format(f fmt.State, verb rune) {
+	formatRedacted(f, verb, ps.String())
+}
+
+// String returns the device status with the private and preshared keys
+// replaced by Redacted, making it safe to log.
+func (st Status) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "{PrivateKey:%s ListenPort:%d Peers:[", Redacted, st.ListenPort)
+	for n, ps := range st.Peers {
+		if n > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(ps.String())
+	}
+	buf.WriteString("]}")
+	return buf.String()
+}
+
+// Format implements fmt.Formatter so that all verbs print the redacted
+// form returned by String.
+func (st Status) Format(f fmt.State, verb rune) {
+	formatRedacted(f, verb, st.String())
+}
+
+// ParseUapi reads WireGuard UAPI text, such as the output of Config.UapiConf
+// or an IpcGet dump, and returns a Config. Runtime fields are ignored.
+// The UAPI text must describe exactly one peer. Since UAPI has no notion of
+// addresses or DNS servers, those fields are left empty.
+func ParseUapi(r io.Reader) (cfg *Config, err error) {
+	var st *Status
+	if st, err = ParseUapiStatus(r); err == nil {
+		err = ErrInvalidInterfacePrivateKey
+		if len(st.PrivateKey) > 0 {
+			err = ErrInvalidPeerPublicKey
+			if len(st.Peers) > 0 {
+				err = ErrUapiMultiplePeers
+				if len(st.Peers) == 1 {
+					err = nil
+					peer := st.Peers[0]
+					cfg = &Config{
+						PrivateKey:          st.PrivateKey,
+						PublicKey:           peer.PublicKey,
+						PresharedKey:        peer.PresharedKey,
+						Endpoint:            peer.Endpoint,
+						AllowedIPs:          peer.AllowedIPs,
+						ListenPort:          st.ListenPort,
+						PersistentKeepalive: peer.PersistentKeepalive,
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// ParseUapiStatus reads WireGuard UAPI text, such as an IpcGet dump,
+// and returns the device Status including all peers.
+func ParseUapiStatus(r io.Reader) (st *Status, err error) {
+	var s Status
+	var peer *PeerStatus
+	var sec, nsec int64
+	sc := bufio.NewScanner(r)
+	for err == nil && sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errors.Join(ErrInvalidUapiLine, errors.New(line))
+		}
+		switch key {
+		case "errno":
+			if value != "0" {
+				err = errors.Join(ErrUapiErrno, errors.New(line))
+			}
+		case "private_key":
+			if s.PrivateKey, err = decodeHexKey(value); err != nil {
+				err = errors.Join(ErrInvalidInterfacePrivateKey, err)
+			}
+		case "listen_port":
+			if s.ListenPort, err = strconv.Atoi(value); err != nil || s.ListenPort < 0 || s.ListenPort > 0xFFFF {
+				err = errors.Join(ErrInvalidInterfaceListenPort, err)
+			}
+		case "public_key":
+			s.Peers = append(s.Peers, PeerStatus{})
+			peer = &s.Peers[len(s.Peers)-1]
+			sec, nsec = 0, 0
+			if peer.PublicKey, err = decodeHexKey(value); err != nil {
+				err = errors.Join(ErrInvalidPeerPublicKey, err)
+			}
+		default:
+			if peer == nil {
+				// ignore unknown or unsupported interface keys such as fwmark
+				continue
+			}
+			switch key {
+			case "preshared_key":
+				if peer.PresharedKey, err = decodeHexKey(value); err != nil {
+					err = errors.Join(ErrInvalidPeerPresharedKey, err)
+				} else if isZeroKey(peer.PresharedKey) {
+					peer.PresharedKey = nil
+				}
+			case "endpoint":
+				if peer.Endpoint, err = netip.ParseAddrPort(value); err != nil {
+					err = errors.Join(ErrInvalidPeerEndpoint, err)
+				}
+			case "allowed_ip":
+				var pf netip.Prefix
+				if pf, err = mustPrefix(value, ErrInvalidPeerAllowedIPs); err == nil {
+					peer.AllowedIPs = append(peer.AllowedIPs, pf)
+				}
+			case "persistent_keepalive_interval":
+				if peer.PersistentKeepalive, err = strconv.Atoi(value); err != nil || peer.PersistentKeepalive < 0 || peer.PersistentKeepalive > 0xFFFF {
+					err = errors.Join(ErrInvalidPeerPersistentKeepalive, err)
+				}
+			case "last_handshake_time_sec":
+				if sec, err = strconv.ParseInt(value, 10, 64); err == nil {
+					peer.LastHandshake = uapiTime(sec, nsec)
+				}
+			case "last_handshake_time_nsec":
+				if nsec, err = strconv.ParseInt(value, 10, 64); err == nil {
+					peer.LastHandshake = uapiTime(sec, nsec)
+				}
+			case "rx_bytes":
+				peer.RxBytes, err = strconv.ParseUint(value, 10, 64)
+			case "tx_bytes":
+				peer.TxBytes, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				err = errors.Join(ErrInvalidUapiLine, err)
+			}
+		}
+	}
+	if err == nil {
+		if err = sc.Err(); err == nil {
+			st = &s
+		}
+	}
+	return
+}
+
+func uapiTime(sec, nsec int64) (t time.Time) {
+	if sec != 0 || nsec != 0 {
+		t = time.Unix(sec, nsec)
+	}
+	return
+}
+
+func isZeroKey(key []byte) bool {
+	for _, b := range key {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package wgnet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+const uapiDump = `private_key=583139415432556c50456641504765764c4c58745446783932554f4e59383351
+listen_port=6789
+fwmark=0
+public_key=583139415432556c50456641504765764c4c58745446783932554f4e59383351
+preshared_key=0000000000000000000000000000000000000000000000000000000000000000
+protocol_version=1
+endpoint=10.0.0.1:1
+last_handshake_time_sec=1700000000
+last_handshake_time_nsec=500
+tx_bytes=1234
+rx_bytes=5678
+persistent_keepalive_interval=10
+allowed_ip=192.168.1.0/24
+allowed_ip=10.0.0.0/8
+public_key=000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+protocol_version=1
+last_handshake_time_sec=0
+last_handshake_time_nsec=0
+tx_bytes=0
+rx_bytes=0
+persistent_keepalive_interval=0
+errno=0
+`
+
+func TestParseUapi_RoundTrip(t *testing.T) {
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	got, err := wgnet.ParseUapi(strings.NewReader(cfg.UapiConf()))
+	maybeFatal(t, err)
+	got.Addresses = cfg.Addresses
+	got.DNS = cfg.DNS
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("mismatch\n got: %#v\nwant: %#v\n", got, cfg)
+	}
+}
+
+func TestParseUapiStatus(t *testing.T) {
+	st, err := wgnet.ParseUapiStatus(strings.NewReader(uapiDump))
+	maybeFatal(t, err)
+	if st.ListenPort != 6789 {
+		t.Error(st.ListenPort)
+	}
+	if len(st.Peers) != 2 {
+		t.Fatal(len(st.Peers))
+	}
+	peer := st.Peers[0]
+	if !peer.LastHandshake.Equal(time.Unix(1700000000, 500)) {
+		t.Error(peer.LastHandshake)
+	}
+	if peer.TxBytes != 1234 || peer.RxBytes != 5678 {
+		t.Error(peer.TxBytes, peer.RxBytes)
+	}
+	if peer.PresharedKey != nil {
+		t.Error(peer.PresharedKey)
+	}
+	if peer.Endpoint != netip.MustParseAddrPort("10.0.0.1:1") {
+		t.Error(peer.Endpoint)
+	}
+	if len(peer.AllowedIPs) != 2 || peer.PersistentKeepalive != 10 {
+		t.Error(peer.AllowedIPs, peer.PersistentKeepalive)
+	}
+	if !st.Peers[1].LastHandshake.IsZero() {
+		t.Error(st.Peers[1].LastHandshake)
+	}
+}
+
+func TestParseUapi_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr error
+	}{
+		{"empty", "", wgnet.ErrInvalidInterfacePrivateKey},
+		{"nopeer", "private_key=583139415432556c50456641504765764c4c58745446783932554f4e59383351\n", wgnet.ErrInvalidPeerPublicKey},
+		{"multiple", uapiDump, wgnet.ErrUapiMultiplePeers},
+		{"errno", "errno=1\n", wgnet.ErrUapiErrno},
+		{"line", "meh\n", wgnet.ErrInvalidUapiLine},
+		{"privatekey", "private_key=meh\n", wgnet.ErrInvalidInterfacePrivateKey},
+		{"listenport", "listen_port=-1\n", wgnet.ErrInvalidInterfaceListenPort},
+		{"publickey", "public_key=00\n", wgnet.ErrInvalidPeerPublicKey},
+		{"endpoint", "public_key=000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\nendpoint=meh\n", wgnet.ErrInvalidPeerEndpoint},
+		{"allowedip", "public_key=000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\nallowed_ip=meh\n", wgnet.ErrInvalidPeerAllowedIPs},
+		{"rxbytes", "public_key=000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\nrx_bytes=meh\n", wgnet.ErrInvalidUapiLine},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := wgnet.ParseUapi(strings.NewReader(tt.text))
+			if cfg != nil {
+				t.Errorf("expected nil config, got %v", cfg)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWgNet_Snapshot(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	_, err := cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+
+	st, err := cli.Status()
+	maybeFatal(t, err)
+	if len(st.Peers) != 1 || st.Peers[0].LastHandshake.IsZero() || st.Peers[0].RxBytes == 0 {
+		t.Errorf("unexpected status %#v", st)
+	}
+	rawKey := strings.Trim(fmt.Sprint(decodeKey("AEnvL9tVr+7JF0sMVjjzPjIxrrc/hoVJ5B82WWpVamI=")), "[]")
+	for _, v := range []any{st, *st, st.Peers} {
+		for _, verb := range []string{"%v", "%+v", "%#v"} {
+			if got := fmt.Sprintf(verb, v); strings.Contains(got, rawKey) || strings.Contains(got, "AEnvL9tVr") {
+				t.Errorf("%s of %T leaks secrets: %s", verb, v, got)
+			}
+		}
+	}
+	if !strings.Contains(st.String(), "PrivateKey:"+wgnet.Redacted) {
+		t.Error(st.String())
+	}
+
+	cfg, err := cli.Snapshot()
+	maybeFatal(t, err)
+	if !strings.Contains(cfg.String(), "Address = 10.131.132.2/24") {
+		t.Error(cfg.String())
+	}
+	cfg.Addresses[0] = netip.MustParsePrefix("10.0.0.1/32")
+	cfg.DNS[0] = netip.MustParseAddr("10.0.0.1")
+	again, err := cli.Snapshot()
+	maybeFatal(t, err)
+	if again.Addresses[0].String() != "10.131.132.2/24" || again.DNS[0].String() != "1.1.1.1" {
+		t.Error("Snapshot shares slices with the running Config")
+	}
+
+	maybeFatal(t, cli.Close())
+	if _, err = cli.Snapshot(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,246 @@
+package wgnet
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/linkdata/deadlock"
+	"golang.org/x/time/rate"
+)
+
+var ErrInvalidRateLimit = errors.New("invalid rate limit")
+
+// RateLimit is a token bucket limit on traffic in bytes per second.
+type RateLimit struct {
+	Rate  int // bytes per second, zero for no limit
+	Burst int // bytes, defaulting to Rate and never less than one packet
+}
+
+// RateLimits configures the token buckets applied by SetRateLimits.
+// Each limit applies separately to each direction.
+type RateLimits struct {
+	Total RateLimit            // all traffic of the WgNet
+	Peer  RateLimit            // traffic to and from each peer
+	Peers map[string]RateLimit // overrides Peer for the peer with this base64 public key
+	Conn  RateLimit            // each TCP or UDP connection dialed or accepted
+}
+
+func (rl RateLimit) valid() bool {
+	return rl.Rate >= 0 && rl.Burst >= 0
+}
+
+// buckets holds a limiter per direction, or nil if unlimited.
+type buckets [2]*rate.Limiter
+
+func newBuckets(rl RateLimit) (b *buckets) {
+	if rl.Rate > 0 {
+		burst := rl.Burst
+		if burst == 0 {
+			burst = rl.Rate
+		}
+		burst = max(burst, tunMTU)
+		b = &buckets{
+			rate.NewLimiter(rate.Limit(rl.Rate), burst),
+			rate.NewLimiter(rate.Limit(rl.Rate), burst),
+		}
+	}
+	return
+}
+
+// reserve takes n tokens for dir from b, returning false if they are not
+// available now. A nil r means b is unlimited.
+func (b *buckets) reserve(now time.Time, dir FirewallDirection, n int) (r *rate.Reservation, ok bool) {
+	ok = true
+	if b != nil {
+		r = b[dir-1].ReserveN(now, n)
+		if ok = r.OK() && r.DelayFrom(now) == 0; !ok {
+			r.CancelAt(now)
+		}
+	}
+	return
+}
+
+// flowKey identifies the packets of a connection from the local side.
+type flowKey struct {
+	proto         uint8
+	local, remote netip.AddrPort
+}
+
+func (pi packetInfo) flow(dir FirewallDirection) flowKey {
+	src, dst := netip.AddrPortFrom(pi.Src, pi.SPort), netip.AddrPortFrom(pi.Dst, pi.DPort)
+	if dir == FirewallOutbound {
+		return flowKey{proto: pi.Proto, local: src, remote: dst}
+	}
+	return flowKey{proto: pi.Proto, local: dst, remote: src}
+}
+
+// connFlow returns the flowKey for the packets of c.
+func connFlow(c net.Conn) (key flowKey, ok bool) {
+	switch local := c.LocalAddr().(type) {
+	case *net.TCPAddr:
+		if remote, isTCP := c.RemoteAddr().(*net.TCPAddr); isTCP {
+			key, ok = flowKey{protoTCP, unmap(local.AddrPort()), unmap(remote.AddrPort())}, true
+		}
+	case *net.UDPAddr:
+		if remote, isUDP := c.RemoteAddr().(*net.UDPAddr); isUDP {
+			key, ok = flowKey{protoUDP, unmap(local.AddrPort()), unmap(remote.AddrPort())}, true
+		}
+	}
+	return
+}
+
+func unmap(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// rateLimiter applies RateLimits to packets.
+type rateLimiter struct {
+	limits RateLimits
+	total  *buckets
+	mu     deadlock.RWMutex // protects following
+	peers  map[string]*buckets
+	conns  map[flowKey]*buckets
+}
+
+func newRateLimiter(limits *RateLimits) (rl *rateLimiter, err error) {
+	rl = &rateLimiter{
+		limits: *limits,
+		total:  newBuckets(limits.Total),
+		peers:  map[string]*buckets{},
+		conns:  map[flowKey]*buckets{},
+	}
+	rl.limits.Peers = map[string]RateLimit{}
+	if !limits.Total.valid() || !limits.Peer.valid() || !limits.Conn.valid() {
+		err = ErrInvalidRateLimit
+	}
+	for k, v := range limits.Peers {
+		key, e := base64.StdEncoding.DecodeString(k)
+		if e != nil || len(key) != 32 || !v.valid() {
+			err = ErrInvalidRateLimit
+		}
+		rl.limits.Peers[string(key)] = v
+	}
+	return
+}
+
+// allow returns true if the packet pi of size bytes travelling in dir is
+// within the limits, where key is the public key of the peer it belongs to.
+// Tokens are only taken if all the limits allow the packet.
+func (rl *rateLimiter) allow(pi packetInfo, dir FirewallDirection, key []byte, size int) bool {
+	now := time.Now()
+	var conn, peer *buckets
+	var found bool
+	rl.mu.RLock()
+	if pi.Proto == protoTCP || pi.Proto == protoUDP {
+		conn = rl.conns[pi.flow(dir)]
+	}
+	if key != nil {
+		peer, found = rl.peers[string(key)]
+	}
+	rl.mu.RUnlock()
+	if key != nil && !found {
+		peer = rl.addPeer(key)
+	}
+	var taken [3]*rate.Reservation
+	for i, b := range [...]*buckets{conn, peer, rl.total} {
+		var ok bool
+		if taken[i], ok = b.reserve(now, dir, size); !ok {
+			for _, r := range taken[:i] {
+				if r != nil {
+					r.CancelAt(now)
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// addPeer returns the buckets for the peer with public key key, creating them if needed.
+func (rl *rateLimiter) addPeer(key []byte) (peer *buckets) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	var found bool
+	if peer, found = rl.peers[string(key)]; !found {
+		limit, override := rl.limits.Peers[string(key)]
+		if !override {
+			limit = rl.limits.Peer
+		}
+		peer = newBuckets(limit)
+		rl.peers[string(key)] = peer
+	}
+	return
+}
+
+// prunePeers forgets the buckets of peers not in pt.
+func (rl *rateLimiter) prunePeers(pt *peerTable) {
+	rl.mu.Lock()
+	for key := range rl.peers {
+		if !pt.has(key) {
+			delete(rl.peers, key)
+		}
+	}
+	rl.mu.Unlock()
+}
+
+// addConn starts limiting the packets of c if there is a Conn limit.
+func (rl *rateLimiter) addConn(c net.Conn) (key flowKey, ok bool) {
+	if rl.limits.Conn.Rate > 0 {
+		if key, ok = connFlow(c); ok {
+			rl.mu.Lock()
+			rl.conns[key] = newBuckets(rl.limits.Conn)
+			rl.mu.Unlock()
+		}
+	}
+	return
+}
+
+func (rl *rateLimiter) removeConn(key flowKey) {
+	rl.mu.Lock()
+	delete(rl.conns, key)
+	rl.mu.Unlock()
+}
+
+// SetRateLimits limits the packets passing between the netstack and the
+// WireGuard device using token buckets, or removes the limits if limits
+// is nil. Packets exceeding a limit are dropped rather than delayed, so
+// one peer or connection cannot hold up the others, and TCP slows down in
+// response. Peers are matched by the AllowedIPs of the running device, and
+// Conn limits apply to connections opened while a Conn limit is set.
+func (wgnet *WgNet) SetRateLimits(limits *RateLimits) (err error) {
+	var rl *rateLimiter
+	if limits != nil {
+		if rl, err = newRateLimiter(limits); err == nil {
+			if prev := wgnet.rl.Load(); prev != nil {
+				prev.mu.Lock()
+				for key := range prev.conns {
+					if b := newBuckets(rl.limits.Conn); b != nil {
+						rl.conns[key] = b
+					}
+				}
+				prev.mu.Unlock()
+			}
+		}
+	}
+	if err == nil {
+		wgnet.rl.Store(rl)
+	}
+	return
+}
+
+// limitConn starts limiting the packets of c, returning ok if it did.
+func (wgnet *WgNet) limitConn(c net.Conn) (key flowKey, ok bool) {
+	if rl := wgnet.rl.Load(); rl != nil {
+		key, ok = rl.addConn(c)
+	}
+	return
+}
+
+func (wgnet *WgNet) unlimitConn(key flowKey) {
+	if rl := wgnet.rl.Load(); rl != nil {
+		rl.removeConn(key)
+	}
+}
@@ -0,0 +1,67 @@
+package wgnet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/netip"
+	"testing"
+)
+
+func TestPeerTable_PeerKey(t *testing.T) {
+	a, b := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	pt := newPeerTable([]PeerStatus{
+		{PublicKey: a, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("fd00::/8")}},
+		{PublicKey: b, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::1/128")}},
+	})
+	tests := []struct {
+		addr string
+		key  []byte
+	}{
+		{"10.1.2.3", b},
+		{"192.168.1.1", a},
+		{"fd00::1", b},
+		{"fd00::2", a},
+		{"2001:db8::1", nil},
+	}
+	for _, tt := range tests {
+		if key := pt.peerKey(netip.MustParseAddr(tt.addr)); !bytes.Equal(key, tt.key) {
+			t.Errorf("%s: got %x, want %x", tt.addr, key, tt.key)
+		}
+	}
+	if key := (*peerTable)(nil).peerKey(netip.MustParseAddr("10.1.2.3")); key != nil {
+		t.Error(key)
+	}
+}
+
+func TestRateLimiter_AllOrNothing(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	rl, err := newRateLimiter(&RateLimits{
+		Total: RateLimit{Rate: tunMTU * 10},
+		Peers: map[string]RateLimit{base64.StdEncoding.EncodeToString(key): {Rate: tunMTU}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi := packetInfo{Src: netip.MustParseAddr("10.0.0.1"), Dst: netip.MustParseAddr("10.0.0.2"), Proto: protoUDP}
+	if !rl.allow(pi, FirewallOutbound, key, tunMTU) {
+		t.Fatal("first packet dropped")
+	}
+	// the peer bucket is empty, so the total bucket must not be charged
+	for range 20 {
+		if rl.allow(pi, FirewallOutbound, key, tunMTU) {
+			t.Fatal("peer limit not applied")
+		}
+	}
+	if tokens := rl.total[FirewallOutbound-1].Tokens(); tokens < tunMTU*8 {
+		t.Errorf("total bucket charged for dropped packets, %v tokens left", tokens)
+	}
+
+	rl.prunePeers(newPeerTable([]PeerStatus{{PublicKey: key, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}}))
+	if len(rl.peers) != 1 {
+		t.Error("current peer pruned")
+	}
+	rl.prunePeers(newPeerTable(nil))
+	if len(rl.peers) != 0 {
+		t.Error("removed peer not pruned")
+	}
+}
@@ -0,0 +1,74 @@
+package wgnet_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+// sendBurst sends count datagrams of 1000 bytes from cli to pc and returns
+// how many arrived.
+func sendBurst(t *testing.T, cli *wgnet.WgNet, pc net.PacketConn, count int) (received int) {
+	t.Helper()
+	c, err := cli.Dial("udp", pc.LocalAddr().String())
+	maybeFatal(t, err)
+	defer c.Close()
+	buf := make([]byte, 1000)
+	for range count {
+		_, err = c.Write(buf)
+		maybeFatal(t, err)
+	}
+	for {
+		maybeFatal(t, pc.SetReadDeadline(time.Now().Add(time.Millisecond*500)))
+		if _, _, err = pc.ReadFrom(buf); err != nil {
+			return
+		}
+		received++
+	}
+}
+
+func TestWgNet_SetRateLimits(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+	pc, err := srv.ListenPacket("udp", "10.131.132.1:9000")
+	maybeFatal(t, err)
+	defer pc.Close()
+
+	if err = cli.SetRateLimits(&wgnet.RateLimits{Total: wgnet.RateLimit{Rate: -1}}); !errors.Is(err, wgnet.ErrInvalidRateLimit) {
+		t.Error(err)
+	}
+	if err = cli.SetRateLimits(&wgnet.RateLimits{Peers: map[string]wgnet.RateLimit{"bad": {Rate: 1}}}); !errors.Is(err, wgnet.ErrInvalidRateLimit) {
+		t.Error(err)
+	}
+
+	// complete the handshake before measuring
+	if n := sendBurst(t, cli, pc, 1); n != 1 {
+		t.Fatal(n)
+	}
+
+	maybeFatal(t, cli.SetRateLimits(&wgnet.RateLimits{Conn: wgnet.RateLimit{Rate: 5000}}))
+	if n := sendBurst(t, cli, pc, 50); n < 1 || n > 15 {
+		t.Errorf("connection limit passed %d packets", n)
+	}
+
+	maybeFatal(t, cli.SetRateLimits(nil))
+	maybeFatal(t, srv.SetRateLimits(&wgnet.RateLimits{
+		Peers: map[string]wgnet.RateLimit{"kTUQWHx4Y3ZYMZQPnRarzlx0qnen3plDoI0z7s45in4=": {Rate: 5000}},
+	}))
+	if n := sendBurst(t, cli, pc, 50); n < 1 || n > 15 {
+		t.Errorf("peer limit passed %d packets", n)
+	}
+
+	maybeFatal(t, srv.SetRateLimits(nil))
+	if n := sendBurst(t, cli, pc, 50); n < 40 {
+		t.Errorf("unlimited passed only %d packets", n)
+	}
+}
@@ -0,0 +1,303 @@
+package wgnet
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var ErrNoDNSServers = errors.New("no DNS servers configured")
+
+// maxCNAMEChain bounds how many CNAME records LookupCNAME follows.
+const maxCNAMEChain = 8
+
+// Resolver returns a *net.Resolver that sends all queries through the
+// tunnel to the DNS servers in the Config, trying them in turn. If the
+// Config has SecureDNS servers, only those are used. It uses the pure Go
+// resolver, so the hosts file, search domains and options of the host
+// apply instead of the Hosts and SearchDomains of the Config. The Lookup
+// methods of WgNet use only the Config.
+func (wgnet *WgNet) Resolver() *net.Resolver {
+	var next atomic.Uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (conn net.Conn, err error) {
+			err = ErrNoDNSServers
+			if wgnet != nil && len(wgnet.cfg.SecureDNS) > 0 {
+				query := func(ctx context.Context, _ string, msg []byte) ([]byte, error) {
+					return wgnet.secureExchange(ctx, msg)
+				}
+				conn, err = &dnsConn{query: query, ctx: ctx}, nil
+			} else if wgnet != nil && len(wgnet.cfg.DNS) > 0 {
+				server := wgnet.cfg.DNS[int(next.Add(1)-1)%len(wgnet.cfg.DNS)]
+				conn, err = wgnet.DialContext(ctx, network, netip.AddrPortFrom(server, 53).String())
+			}
+			return
+		},
+	}
+}
+
+// exchange sends msg through the tunnel to the SecureDNS servers of the
+// Config if there are any, or else to the DNS servers in turn.
+func (wgnet *WgNet) exchange(ctx context.Context, msg []byte) (resp []byte, err error) {
+	if _, err = wgnet.getnet(); err == nil {
+		if len(wgnet.cfg.SecureDNS) > 0 {
+			return wgnet.secureExchange(ctx, msg)
+		}
+		err = ErrNoDNSServers
+		for _, server := range wgnet.cfg.DNS {
+			if resp, err = dnsExchange(ctx, wgnet.DialContext, netip.AddrPortFrom(server, 53).String(), msg); err == nil {
+				break
+			}
+		}
+	}
+	return
+}
+
+// queryName returns the qtype answers for the absolute name.
+func (wgnet *WgNet) queryName(ctx context.Context, name string, qtype dnsmessage.Type) (answers []dnsmessage.Resource, err error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	var qname dnsmessage.Name
+	if qname, err = dnsmessage.NewName(name); err == nil {
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: uint16(rand.IntN(1 << 16)), RecursionDesired: true}, // #nosec G115 G404
+			Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+		}
+		var b []byte
+		if b, err = msg.Pack(); err == nil {
+			if b, err = wgnet.exchange(ctx, b); err == nil {
+				if err = msg.Unpack(b); err == nil {
+					dnserr := &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+					if msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError {
+						dnserr = &net.DNSError{Err: "server misbehaving: " + msg.RCode.String(), Name: name, IsTemporary: true}
+					}
+					for _, rr := range msg.Answers {
+						if rr.Header.Type == qtype {
+							answers = append(answers, rr)
+						}
+					}
+					if len(answers) == 0 || msg.RCode != dnsmessage.RCodeSuccess {
+						answers, err = nil, dnserr
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// query returns the qtype answers for host, trying the names from
+// SearchDomains in turn, and the absolute name that had them.
+func (wgnet *WgNet) query(ctx context.Context, host string, qtype dnsmessage.Type) (name string, answers []dnsmessage.Resource, err error) {
+	err = net.ErrClosed
+	if wgnet != nil {
+		for _, name = range wgnet.cfg.searchNames(host) {
+			if answers, err = wgnet.queryName(ctx, name, qtype); err == nil {
+				name = absName(name)
+				break
+			}
+		}
+	}
+	return
+}
+
+func absName(name string) string {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// queryHost returns the A and AAAA answers for the absolute name as strings.
+func (wgnet *WgNet) queryHost(ctx context.Context, name string) (addrs []string, err error) {
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, e := wgnet.queryName(ctx, name, qtype)
+		for _, rr := range answers {
+			switch body := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, netip.AddrFrom4(body.A).String())
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, netip.AddrFrom16(body.AAAA).String())
+			}
+		}
+		if err == nil {
+			err = e
+		}
+	}
+	if len(addrs) > 0 {
+		err = nil
+	}
+	return
+}
+
+// LookupIP looks up host through the tunnel, see net.Resolver.LookupIP.
+func (wgnet *WgNet) LookupIP(ctx context.Context, network, host string) (ips []net.IP, err error) {
+	var addrs []netip.Addr
+	if addrs, err = wgnet.LookupNetIP(ctx, network, host); err == nil {
+		for _, addr := range addrs {
+			ips = append(ips, net.IP(addr.AsSlice()))
+		}
+	}
+	return
+}
+
+// LookupNetIP looks up host through the tunnel, see net.Resolver.LookupNetIP.
+// network must be "ip", "ip4" or "ip6".
+func (wgnet *WgNet) LookupNetIP(ctx context.Context, network, host string) (addrs []netip.Addr, err error) {
+	switch network {
+	case "ip", "ip4", "ip6":
+		var hosts []string
+		if hosts, err = wgnet.LookupHost(ctx, host); err == nil {
+			for _, h := range hosts {
+				if addr, e := netip.ParseAddr(h); e == nil {
+					if addr = addr.Unmap(); (addr.Is4() && network != "ip6") || (addr.Is6() && network != "ip4") {
+						addrs = append(addrs, addr)
+					}
+				}
+			}
+			if len(addrs) == 0 {
+				err = &net.DNSError{Err: "no suitable address", Name: host, IsNotFound: true}
+			}
+		}
+	default:
+		err = net.UnknownNetworkError(network)
+	}
+	return
+}
+
+// LookupSRV looks up SRV records through the tunnel, see net.Resolver.LookupSRV.
+func (wgnet *WgNet) LookupSRV(ctx context.Context, service, proto, name string) (cname string, srvs []*net.SRV, err error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	var answers []dnsmessage.Resource
+	if cname, answers, err = wgnet.query(ctx, target, dnsmessage.TypeSRV); err == nil {
+		for _, rr := range answers {
+			if srv, ok := rr.Body.(*dnsmessage.SRVResource); ok {
+				srvs = append(srvs, &net.SRV{Target: srv.Target.String(), Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+			}
+		}
+		slices.SortStableFunc(srvs, func(a, b *net.SRV) int {
+			return cmp.Or(cmp.Compare(a.Priority, b.Priority), cmp.Compare(b.Weight, a.Weight))
+		})
+	}
+	return
+}
+
+// LookupMX looks up MX records through the tunnel, see net.Resolver.LookupMX.
+func (wgnet *WgNet) LookupMX(ctx context.Context, name string) (mxs []*net.MX, err error) {
+	var answers []dnsmessage.Resource
+	if _, answers, err = wgnet.query(ctx, name, dnsmessage.TypeMX); err == nil {
+		for _, rr := range answers {
+			if mx, ok := rr.Body.(*dnsmessage.MXResource); ok {
+				mxs = append(mxs, &net.MX{Host: mx.MX.String(), Pref: mx.Pref})
+			}
+		}
+		slices.SortStableFunc(mxs, func(a, b *net.MX) int {
+			return cmp.Compare(a.Pref, b.Pref)
+		})
+	}
+	return
+}
+
+// LookupTXT looks up TXT records through the tunnel, see net.Resolver.LookupTXT.
+func (wgnet *WgNet) LookupTXT(ctx context.Context, name string) (txts []string, err error) {
+	var answers []dnsmessage.Resource
+	if _, answers, err = wgnet.query(ctx, name, dnsmessage.TypeTXT); err == nil {
+		for _, rr := range answers {
+			if txt, ok := rr.Body.(*dnsmessage.TXTResource); ok {
+				txts = append(txts, strings.Join(txt.TXT, ""))
+			}
+		}
+	}
+	return
+}
+
+// LookupAddr does a reverse lookup through the tunnel, see net.Resolver.LookupAddr.
+// Names in the Hosts of the Config are returned without querying DNS.
+func (wgnet *WgNet) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	var ip netip.Addr
+	if ip, err = netip.ParseAddr(addr); err != nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	ip = ip.Unmap()
+	err = net.ErrClosed
+	if wgnet != nil {
+		for name, addrs := range wgnet.cfg.Hosts {
+			if slices.Contains(addrs, ip) {
+				names = append(names, absName(name))
+			}
+		}
+		if len(names) > 0 {
+			slices.Sort(names)
+			return names, nil
+		}
+		var answers []dnsmessage.Resource
+		if answers, err = wgnet.queryName(ctx, reverseName(ip), dnsmessage.TypePTR); err == nil {
+			for _, rr := range answers {
+				if ptr, ok := rr.Body.(*dnsmessage.PTRResource); ok {
+					names = append(names, ptr.PTR.String())
+				}
+			}
+		}
+	}
+	return
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name for ip.
+func reverseName(ip netip.Addr) string {
+	var sb strings.Builder
+	b := ip.AsSlice()
+	for i := len(b) - 1; i >= 0; i-- {
+		if ip.Is4() {
+			sb.WriteString(strconv.Itoa(int(b[i])))
+			sb.WriteByte('.')
+		} else {
+			const hex = "0123456789abcdef"
+			sb.Write([]byte{hex[b[i]&0xf], '.', hex[b[i]>>4], '.'})
+		}
+	}
+	if ip.Is4() {
+		sb.WriteString("in-addr.arpa.")
+	} else {
+		sb.WriteString("ip6.arpa.")
+	}
+	return sb.String()
+}
+
+// LookupCNAME looks up the canonical name through the tunnel, see net.Resolver.LookupCNAME.
+func (wgnet *WgNet) LookupCNAME(ctx context.Context, host string) (cname string, err error) {
+	var answers []dnsmessage.Resource
+	if cname, answers, err = wgnet.query(ctx, host, dnsmessage.TypeCNAME); err == nil {
+		for range maxCNAMEChain {
+			if rr, ok := answers[0].Body.(*dnsmessage.CNAMEResource); ok {
+				cname = rr.CNAME.String()
+			}
+			if answers, err = wgnet.queryName(ctx, cname, dnsmessage.TypeCNAME); err != nil {
+				err = nil
+				break
+			}
+		}
+	} else if wgnet != nil {
+		// no CNAME, so the canonical name is the one that has addresses
+		for _, name := range wgnet.cfg.searchNames(host) {
+			if _, err = wgnet.queryHost(ctx, name); err == nil {
+				cname = absName(name)
+				break
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package wgnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+var ErrSecureDNS = errors.New("secure DNS query failed")
+
+// isSecureDNS returns true if s is a DNS-over-HTTPS (https://) or
+// DNS-over-TLS (tls://) server URL.
+func isSecureDNS(s string) (ok bool) {
+	if u, err := url.Parse(s); err == nil && u.Host != "" && u.User == nil {
+		switch u.Scheme {
+		case "https":
+			ok = true
+		case "tls":
+			ok = u.Path == "" && u.RawQuery == ""
+		}
+	}
+	return
+}
+
+// SetTLSConfig sets the TLS configuration used to connect to DNS-over-HTTPS
+// and DNS-over-TLS servers. A nil config uses the system roots.
+func (wgnet *WgNet) SetTLSConfig(tlsConfig *tls.Config) {
+	wgnet.mu.Lock()
+	wgnet.tlscfg = tlsConfig
+	wgnet.doh = nil
+	wgnet.mu.Unlock()
+}
+
+func (wgnet *WgNet) secureClient() (tlsConfig *tls.Config, doh *http.Client) {
+	wgnet.mu.Lock()
+	defer wgnet.mu.Unlock()
+	if wgnet.doh == nil {
+		wgnet.doh = &http.Client{
+			Transport: &http.Transport{
+				DialContext:       wgnet.secureDial,
+				TLSClientConfig:   wgnet.tlscfg,
+				ForceAttemptHTTP2: true,
+			},
+			Timeout: dnsTimeout,
+		}
+	}
+	return wgnet.tlscfg, wgnet.doh
+}
+
+// secureDial dials a SecureDNS server through the tunnel. A host name is
+// resolved using only the Hosts and plain DNS servers of the Config, since
+// resolving it using the SecureDNS servers would recurse.
+func (wgnet *WgNet) secureDial(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	var ns *netstack.Net
+	if ns, err = wgnet.getnet(); err == nil {
+		var host, port string
+		if host, port, err = net.SplitHostPort(address); err == nil {
+			if _, e := netip.ParseAddr(host); e == nil {
+				return ns.DialContext(ctx, network, address)
+			}
+			addrs := wgnet.cfg.lookupHosts(host)
+			if len(addrs) == 0 {
+				err = ErrNoDNSServers
+				if len(wgnet.cfg.DNS) > 0 {
+					addrs, err = ns.LookupContextHost(ctx, strings.TrimSuffix(host, "."))
+				}
+			}
+			for _, addr := range addrs {
+				var e error
+				if conn, e = ns.DialContext(ctx, network, net.JoinHostPort(addr, port)); e == nil {
+					return conn, nil
+				}
+				if err == nil {
+					err = e
+				}
+			}
+		}
+	}
+	return
+}
+
+// secureExchange sends msg to the SecureDNS servers of the Config in
+// order through the tunnel, returning the first response.
+func (wgnet *WgNet) secureExchange(ctx context.Context, msg []byte) (resp []byte, err error) {
+	err = ErrNoDNSServers
+	for _, server := range wgnet.cfg.SecureDNS {
+		var u *url.URL
+		if u, err = url.Parse(server); err == nil {
+			if u.Scheme == "tls" {
+				resp, err = wgnet.dotExchange(ctx, u, msg)
+			} else {
+				resp, err = wgnet.dohExchange(ctx, u, msg)
+			}
+		}
+		if err == nil {
+			break
+		}
+		err = errors.Join(ErrSecureDNS, err)
+	}
+	return
+}
+
+func (wgnet *WgNet) dohExchange(ctx context.Context, u *url.URL, msg []byte) (resp []byte, err error) {
+	_, client := wgnet.secureClient()
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(msg)); err == nil {
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		var hresp *http.Response
+		if hresp, err = client.Do(req); err == nil {
+			defer hresp.Body.Close()
+			if err = fmt.Errorf("%s: %s", u.Host, hresp.Status); hresp.StatusCode == http.StatusOK {
+				resp, err = io.ReadAll(io.LimitReader(hresp.Body, 0xFFFF))
+			}
+		}
+	}
+	return
+}
+
+func (wgnet *WgNet) dotExchange(ctx context.Context, u *url.URL, msg []byte) (resp []byte, err error) {
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "853")
+	}
+	tlsConfig, _ := wgnet.secureClient()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = u.Hostname()
+	var c net.Conn
+	if c, err = wgnet.secureDial(ctx, "tcp", address); err == nil {
+		tc := tls.Client(c, tlsConfig)
+		defer tc.Close()
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(dnsTimeout)
+		}
+		if err = tc.SetDeadline(deadline); err == nil {
+			if err = tc.HandshakeContext(ctx); err == nil {
+				if _, err = tc.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err == nil { // #nosec G115
+					var lenbuf [2]byte
+					if _, err = io.ReadFull(tc, lenbuf[:]); err == nil {
+						resp = make([]byte, binary.BigEndian.Uint16(lenbuf[:]))
+						_, err = io.ReadFull(tc, resp)
+					}
+				}
+			}
+		}
+	}
+	return
+}
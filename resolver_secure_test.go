@@ -0,0 +1,231 @@
+package wgnet_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// selfSignedCert returns a certificate for ip and names and a pool that trusts it.
+func selfSignedCert(t *testing.T, ip net.IP, names ...string) (cert tls.Certificate, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	maybeFatal(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: ip.String()},
+		IPAddresses:  []net.IP{ip},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	maybeFatal(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	maybeFatal(t, err)
+	pool = x509.NewCertPool()
+	pool.AddCert(leaf)
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	return
+}
+
+func answerDNS(h wgnet.DNSHandler, msg []byte) (b []byte) {
+	var req dnsmessage.Message
+	if req.Unpack(msg) == nil {
+		if resp := h.ServeDNS(context.Background(), &req); resp != nil {
+			b, _ = resp.Pack()
+		}
+	}
+	return
+}
+
+func TestWgNet_SecureDNS(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	handler := &wgnet.StaticDNSHandler{Records: fakeRecords}
+	cert, pool := selfSignedCert(t, net.IPv4(10, 131, 132, 1), "dns.corp.test")
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	hl, err := srv.Listen("tcp", "10.131.132.1:443")
+	maybeFatal(t, err)
+	hsrv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			msg, _ := io.ReadAll(r.Body)
+			if r.Header.Get("Content-Type") != "application/dns-message" {
+				http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			w.Header().Set("Content-Type", "application/dns-message")
+			_, _ = w.Write(answerDNS(handler, msg))
+		}),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: time.Second * 5,
+	}
+	go func() { _ = hsrv.ServeTLS(hl, "", "") }()
+	defer hsrv.Close()
+
+	tl, err := srv.Listen("tcp", "10.131.132.1:853")
+	maybeFatal(t, err)
+	defer tl.Close()
+	go func() {
+		l := tls.NewListener(tl, tlsConfig)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var lenbuf [2]byte
+				if _, err := io.ReadFull(c, lenbuf[:]); err == nil {
+					msg := make([]byte, binary.BigEndian.Uint16(lenbuf[:]))
+					if _, err = io.ReadFull(c, msg); err == nil {
+						b := answerDNS(handler, msg)
+						_, _ = c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+					}
+				}
+			}()
+		}
+	}()
+
+	for _, server := range []string{"https://10.131.132.1/dns-query", "tls://10.131.132.1", "https://dns.corp.test/dns-query", "tls://dns.corp.test"} {
+		t.Run(strings.NewReplacer(":", "", "/", "").Replace(server), func(t *testing.T) {
+			// the server host name must resolve from Hosts, not the SecureDNS servers
+			text := strings.Replace(fmt.Sprintf(clientConfig, listenPort), "DNS = 1.1.1.1", "DNS = "+server+", corp.test", 1) + "\n[Hosts]\ndns.corp.test = 10.131.132.1\n"
+			cliCfg, err := wgnet.Parse(strings.NewReader(text), nil)
+			maybeFatal(t, err)
+			if len(cliCfg.DNS) != 0 || len(cliCfg.SecureDNS) != 1 || cliCfg.SecureDNS[0] != server {
+				t.Fatal(cliCfg.DNS, cliCfg.SecureDNS)
+			}
+			cli := wgnet.New(cliCfg)
+			cli.SetTLSConfig(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+			// wait for the handshake, as a retried one takes device.RekeyTimeout
+			ctx, cancel := context.WithTimeout(t.Context(), time.Second*20)
+			defer cancel()
+			maybeFatal(t, cli.OpenContext(ctx, &wgnet.OpenOptions{WaitHandshake: true}))
+			defer func() {
+				maybeFatal(t, cli.Close())
+			}()
+
+			addrs, err := cli.LookupHost(ctx, "db")
+			maybeFatal(t, err)
+			if len(addrs) != 1 || addrs[0] != "10.131.132.1" {
+				t.Error(addrs)
+			}
+			txts, err := cli.LookupTXT(ctx, "corp.test.")
+			maybeFatal(t, err)
+			if len(txts) != 1 {
+				t.Error(txts)
+			}
+			c, err := cli.DialContext(ctx, "tcp", "db.corp.test:853")
+			maybeFatal(t, err)
+			maybeFatal(t, c.Close())
+		})
+	}
+
+	cliCfg, err := wgnet.Parse(strings.NewReader(strings.Replace(fmt.Sprintf(clientConfig, listenPort), "DNS = 1.1.1.1", "DNS = tls://10.131.132.1", 1)), nil)
+	maybeFatal(t, err)
+	cli := wgnet.New(cliCfg)
+	maybeFatal(t, cli.Open())
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	if _, err = cli.LookupHost(ctx, "db.corp.test"); err == nil {
+		t.Error("expected untrusted certificate to fail")
+	}
+
+	// a server host name with no Hosts entry or plain DNS server can't resolve
+	cliCfg, err = wgnet.Parse(strings.NewReader(strings.Replace(fmt.Sprintf(clientConfig, listenPort), "DNS = 1.1.1.1", "DNS = tls://dns.corp.test", 1)), nil)
+	maybeFatal(t, err)
+	cli2 := wgnet.New(cliCfg)
+	maybeFatal(t, cli2.Open())
+	defer func() {
+		maybeFatal(t, cli2.Close())
+	}()
+	if _, err = cli2.LookupHost(ctx, "db.corp.test"); !errors.Is(err, wgnet.ErrNoDNSServers) {
+		t.Error(err)
+	}
+}
+
+func TestWgNet_SecureDNS_Timeout(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	// a DoT server that completes the TLS handshake but never answers
+	cert, pool := selfSignedCert(t, net.IPv4(10, 131, 132, 1))
+	tl, err := srv.Listen("tcp", "10.131.132.1:853")
+	maybeFatal(t, err)
+	defer tl.Close()
+	go func() {
+		l := tls.NewListener(tl, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+
+	cliCfg, err := wgnet.Parse(strings.NewReader(strings.Replace(fmt.Sprintf(clientConfig, listenPort), "DNS = 1.1.1.1", "DNS = tls://10.131.132.1", 1)), nil)
+	maybeFatal(t, err)
+	cli := wgnet.New(cliCfg)
+	cli.SetTLSConfig(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	maybeFatal(t, cli.Open())
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := cli.LookupHost(context.Background(), "db.corp.test")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("expected lookup to fail")
+		}
+	case <-time.After(time.Second * 30):
+		t.Fatal("lookup without a deadline hangs")
+	}
+}
@@ -0,0 +1,292 @@
+package wgnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/linkdata/deadlock"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var ErrInvalidDNSMessage = errors.New("invalid DNS message")
+
+const (
+	// dnsTimeout is used for upstream queries when ctx has no deadline.
+	dnsTimeout = time.Second * 5
+	// maxCacheEntries bounds the size of the SplitResolver cache.
+	maxCacheEntries = 4096
+)
+
+type dnsCacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type dnsCacheEntry struct {
+	resp    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// SplitResolver is a caching DNS layer that sends queries for names in
+// Domains through the tunnel to the DNS servers in the Config, using the
+// SecureDNS servers instead of the plain ones if there are any, and other
+// queries to FallbackServer or the host DNS servers. Responses are cached
+// for their TTL, or the SOA minimum for negative responses.
+//
+// Use Resolver to get a *net.Resolver for lookups, or to set as
+// Dialer.Resolver.
+type SplitResolver struct {
+	WgNet *WgNet
+	// Domains are the domains whose names, including subdomains, are
+	// resolved through the tunnel, e.g. "corp.internal" or "*.corp.internal".
+	// If empty, the SearchDomains of the Config are used, and if there are
+	// none either, all names are resolved through the tunnel.
+	Domains []string
+	// FallbackServer is the DNS server for names not in Domains. If not
+	// set, the servers of the host are used.
+	FallbackServer netip.AddrPort
+	next           atomic.Uint32
+	mu             deadlock.Mutex // protects following
+	cache          map[dnsCacheKey]dnsCacheEntry
+}
+
+// Resolver returns a *net.Resolver that uses sr.
+func (sr *SplitResolver) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return &dnsConn{query: sr.query, ctx: ctx, address: address}, nil
+		},
+	}
+}
+
+// Flush empties the cache.
+func (sr *SplitResolver) Flush() {
+	sr.mu.Lock()
+	sr.cache = nil
+	sr.mu.Unlock()
+}
+
+// routed returns true if name should be resolved through the tunnel.
+func (sr *SplitResolver) routed(name string) bool {
+	domains := sr.Domains
+	if len(domains) == 0 && sr.WgNet != nil {
+		domains = sr.WgNet.cfg.SearchDomains
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, domain := range domains {
+		domain = strings.Trim(strings.TrimPrefix(strings.ToLower(domain), "*"), ".")
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return len(domains) == 0
+}
+
+// query answers the DNS message msg from the cache or upstream. address is
+// the host DNS server chosen by the Go resolver.
+func (sr *SplitResolver) query(ctx context.Context, address string, msg []byte) (resp []byte, err error) {
+	var p dnsmessage.Parser
+	var q dnsmessage.Question
+	if _, err = p.Start(msg); err == nil {
+		q, err = p.Question()
+	}
+	if err != nil {
+		return nil, errors.Join(ErrInvalidDNSMessage, err)
+	}
+	key := dnsCacheKey{name: strings.ToLower(q.Name.String()), qtype: q.Type, class: q.Class}
+	now := time.Now()
+	sr.mu.Lock()
+	entry, ok := sr.cache[key]
+	sr.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		resp = ageTTLs(entry.resp, now.Sub(entry.stored))
+		copy(resp[:2], msg[:2])
+		return
+	}
+	if sr.routed(q.Name.String()) {
+		var dns []netip.Addr
+		if sr.WgNet != nil {
+			dns = sr.WgNet.cfg.DNS
+		}
+		err = ErrNoDNSServers
+		if sr.WgNet != nil && len(sr.WgNet.cfg.SecureDNS) > 0 {
+			resp, err = sr.WgNet.secureExchange(ctx, msg)
+		} else if len(dns) > 0 {
+			server := netip.AddrPortFrom(dns[int(sr.next.Add(1)-1)%len(dns)], 53)
+			resp, err = dnsExchange(ctx, sr.WgNet.DialContext, server.String(), msg)
+		}
+	} else {
+		if sr.FallbackServer.IsValid() {
+			address = sr.FallbackServer.String()
+		}
+		var d net.Dialer
+		resp, err = dnsExchange(ctx, d.DialContext, address, msg)
+	}
+	if err == nil {
+		if ttl, ok := dnsCacheTTL(resp); ok {
+			sr.mu.Lock()
+			if sr.cache == nil || len(sr.cache) >= maxCacheEntries {
+				sr.cache = make(map[dnsCacheKey]dnsCacheEntry)
+			}
+			sr.cache[key] = dnsCacheEntry{resp: bytes.Clone(resp), stored: now, expires: now.Add(ttl)}
+			sr.mu.Unlock()
+		}
+	}
+	return
+}
+
+// ageTTLs returns a copy of resp with the TTLs of its records reduced by
+// the time it has been cached.
+func ageTTLs(resp []byte, age time.Duration) []byte {
+	var msg dnsmessage.Message
+	if age >= time.Second && msg.Unpack(resp) == nil {
+		secs := uint32(age / time.Second) // #nosec G115
+		for _, rrs := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+			for i := range rrs {
+				if rrs[i].Header.Type != dnsmessage.TypeOPT {
+					rrs[i].Header.TTL -= min(rrs[i].Header.TTL, secs)
+				}
+			}
+		}
+		if b, err := msg.Pack(); err == nil {
+			return b
+		}
+	}
+	return bytes.Clone(resp)
+}
+
+// dnsCacheTTL returns how long resp may be cached. Only successful and
+// name error responses are cached.
+func dnsCacheTTL(resp []byte) (ttl time.Duration, ok bool) {
+	var p dnsmessage.Parser
+	var h dnsmessage.Header
+	var err error
+	if h, err = p.Start(resp); err == nil && !h.Truncated && (h.RCode == dnsmessage.RCodeSuccess || h.RCode == dnsmessage.RCodeNameError) {
+		if err = p.SkipAllQuestions(); err == nil {
+			var answers []dnsmessage.Resource
+			if answers, err = p.AllAnswers(); err == nil {
+				var minTTL uint32
+				for _, rr := range answers {
+					if !ok || rr.Header.TTL < minTTL {
+						minTTL, ok = rr.Header.TTL, true
+					}
+				}
+				if !ok {
+					// negative responses are cached for the SOA minimum
+					var auths []dnsmessage.Resource
+					if auths, err = p.AllAuthorities(); err == nil {
+						for _, rr := range auths {
+							if soa, isSOA := rr.Body.(*dnsmessage.SOAResource); isSOA {
+								minTTL, ok = soa.MinTTL, true
+								if rr.Header.TTL < minTTL {
+									minTTL = rr.Header.TTL
+								}
+							}
+						}
+					}
+				}
+				ok = ok && minTTL > 0
+				ttl = time.Duration(minTTL) * time.Second
+			}
+		}
+	}
+	return
+}
+
+// dnsExchange sends msg to address over UDP, retrying over TCP if the
+// response is truncated.
+func dnsExchange(ctx context.Context, dial DialFunc, address string, msg []byte) (resp []byte, err error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dnsTimeout)
+	}
+	var c net.Conn
+	if c, err = dial(ctx, "udp", address); err == nil {
+		defer c.Close()
+		if err = c.SetDeadline(deadline); err == nil {
+			if _, err = c.Write(msg); err == nil {
+				buf := make([]byte, 0xFFFF)
+				for err == nil && resp == nil {
+					var n int
+					if n, err = c.Read(buf); err == nil && n >= 12 && bytes.Equal(buf[:2], msg[:2]) {
+						resp = bytes.Clone(buf[:n])
+					}
+				}
+			}
+		}
+	}
+	if err == nil && resp[2]&0x02 != 0 {
+		// truncated, retry over TCP
+		resp = nil
+		var tc net.Conn
+		if tc, err = dial(ctx, "tcp", address); err == nil {
+			defer tc.Close()
+			if err = tc.SetDeadline(deadline); err == nil {
+				if _, err = tc.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err == nil { // #nosec G115
+					var lenbuf [2]byte
+					if _, err = io.ReadFull(tc, lenbuf[:]); err == nil {
+						resp = make([]byte, binary.BigEndian.Uint16(lenbuf[:]))
+						_, err = io.ReadFull(tc, resp)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// dnsQueryFunc answers the DNS message msg. address is the host DNS
+// server chosen by the Go resolver.
+type dnsQueryFunc func(ctx context.Context, address string, msg []byte) (resp []byte, err error)
+
+// dnsConn is a stream net.Conn that the Go resolver uses to send
+// length-prefixed DNS messages to a dnsQueryFunc.
+type dnsConn struct {
+	query   dnsQueryFunc
+	ctx     context.Context
+	address string
+	in      bytes.Buffer
+	out     bytes.Buffer
+}
+
+func (c *dnsConn) Write(b []byte) (n int, err error) {
+	n, _ = c.in.Write(b)
+	for err == nil && c.in.Len() >= 2 {
+		msglen := int(binary.BigEndian.Uint16(c.in.Bytes()))
+		if c.in.Len() < 2+msglen {
+			break
+		}
+		msg := c.in.Next(2 + msglen)[2:]
+		var resp []byte
+		if resp, err = c.query(c.ctx, c.address, msg); err == nil {
+			c.out.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))) // #nosec G115
+			c.out.Write(resp)
+		}
+	}
+	return
+}
+
+func (c *dnsConn) Read(b []byte) (n int, err error) {
+	if c.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.out.Read(b)
+}
+
+func (c *dnsConn) Close() error                     { return nil }
+func (c *dnsConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *dnsConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (c *dnsConn) SetDeadline(time.Time) error      { return nil }
+func (c *dnsConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *dnsConn) SetWriteDeadline(time.Time) error { return nil }
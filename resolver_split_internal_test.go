@@ -0,0 +1,48 @@
+package wgnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSplitResolver_CacheAgesTTLs(t *testing.T) {
+	name := dnsmessage.MustNewName("db.corp.test.")
+	q := dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	cached := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 1, Response: true},
+		Questions: []dnsmessage.Question{q},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{10, 131, 132, 1}},
+		}},
+	}
+	resp, err := cached.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	sr := &SplitResolver{cache: map[dnsCacheKey]dnsCacheEntry{
+		{name: strings.ToLower(name.String()), qtype: q.Type, class: q.Class}: {resp: resp, stored: now.Add(-time.Second * 10), expires: now.Add(time.Second * 50)},
+	}}
+	query := dnsmessage.Message{Header: dnsmessage.Header{ID: 2}, Questions: []dnsmessage.Question{q}}
+	msg, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = sr.query(t.Context(), "", msg); err != nil {
+		t.Fatal(err)
+	}
+	var got dnsmessage.Message
+	if err = got.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 2 || len(got.Answers) != 1 {
+		t.Fatal(got)
+	}
+	if ttl := got.Answers[0].Header.TTL; ttl < 49 || ttl > 50 {
+		t.Error(ttl)
+	}
+}
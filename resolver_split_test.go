@@ -0,0 +1,72 @@
+package wgnet_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSplitResolver(t *testing.T) {
+	_, cli, pc := makeDNSNets(t)
+
+	hostpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	maybeFatal(t, err)
+	defer hostpc.Close()
+	go serveFakeDNS(hostpc, []dnsmessage.Resource{
+		{Header: dnsHeader("www.example.test.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+	})
+
+	sr := &wgnet.SplitResolver{
+		WgNet:          cli,
+		Domains:        []string{"*.corp.test"},
+		FallbackServer: netip.MustParseAddrPort(hostpc.LocalAddr().String()),
+	}
+	r := sr.Resolver()
+	lookup := func(host string) (addr netip.Addr, err error) {
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		var addrs []netip.Addr
+		if addrs, err = r.LookupNetIP(ctx, "ip4", host); err == nil {
+			addr = addrs[0]
+		}
+		return
+	}
+
+	addr, err := lookup("db.corp.test.")
+	maybeFatal(t, err)
+	if addr != netip.MustParseAddr("10.131.132.1") {
+		t.Error(addr)
+	}
+	addr, err = lookup("short.corp.test.")
+	maybeFatal(t, err)
+	if addr != netip.MustParseAddr("10.131.132.3") {
+		t.Error(addr)
+	}
+	addr, err = lookup("www.example.test.")
+	maybeFatal(t, err)
+	if addr != netip.MustParseAddr("192.0.2.1") {
+		t.Error(addr)
+	}
+	if _, err = lookup("www.corp.example.test."); err == nil {
+		t.Error("expected not found from the fallback server")
+	}
+
+	// answers are served from the cache once the tunnel DNS server is gone
+	maybeFatal(t, pc.Close())
+	if _, err = lookup("db.corp.test."); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(time.Millisecond * 1500)
+	if _, err = lookup("short.corp.test."); err == nil {
+		t.Error("expected TTL to have expired")
+	}
+	sr.Flush()
+	if _, err = lookup("db.corp.test."); err == nil {
+		t.Error("expected error after Flush")
+	}
+}
@@ -0,0 +1,229 @@
+package wgnet_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveFakeDNS answers queries on pc from records until pc is closed.
+// Names with no records at all get NXDOMAIN.
+func serveFakeDNS(pc net.PacketConn, records []dnsmessage.Resource) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if msg.Unpack(buf[:n]) != nil || len(msg.Questions) == 0 {
+			continue
+		}
+		q := msg.Questions[0]
+		msg.Response = true
+		msg.Authoritative = true
+		msg.RCode = dnsmessage.RCodeNameError
+		msg.Answers = nil
+		msg.Additionals = nil
+		for _, rr := range records {
+			if strings.EqualFold(rr.Header.Name.String(), q.Name.String()) {
+				msg.RCode = dnsmessage.RCodeSuccess
+				if rr.Header.Type == q.Type {
+					msg.Answers = append(msg.Answers, rr)
+				}
+			}
+		}
+		if b, err := msg.Pack(); err == nil {
+			_, _ = pc.WriteTo(b, from)
+		}
+	}
+}
+
+func dnsHeader(name string, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: 60}
+}
+
+var fakeRecords = []dnsmessage.Resource{
+	{Header: dnsHeader("db.corp.test.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{10, 131, 132, 1}}},
+	{Header: dnsHeader("www.corp.test.", dnsmessage.TypeCNAME), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("db.corp.test.")}},
+	{Header: dnsHeader("_pg._tcp.corp.test.", dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Priority: 1, Weight: 1, Port: 5432, Target: dnsmessage.MustNewName("db.corp.test.")}},
+	{Header: dnsHeader("corp.test.", dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.corp.test.")}},
+	{Header: dnsHeader("corp.test.", dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}},
+	{Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("short.corp.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 1}, Body: &dnsmessage.AResource{A: [4]byte{10, 131, 132, 3}}},
+	{Header: dnsHeader("1.132.131.10.in-addr.arpa.", dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("db.corp.test.")}},
+}
+
+// makeDNSNets is makeNets with a fake DNS server on the server side
+// of the tunnel, used as the only DNS server of the client.
+func makeDNSNets(t *testing.T, searchDomains ...string) (srv, cli *wgnet.WgNet, pc net.PacketConn) {
+	t.Helper()
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg.DNS = []netip.Addr{netip.MustParseAddr("10.131.132.1")}
+	cliCfg.SearchDomains = searchDomains
+	srv = wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	t.Cleanup(func() { _ = srv.Close() })
+	cli = wgnet.New(cliCfg)
+	maybeFatal(t, cli.Open())
+	t.Cleanup(func() { _ = cli.Close() })
+	pc, err = srv.ListenPacket("udp", "10.131.132.1:53")
+	maybeFatal(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go serveFakeDNS(pc, fakeRecords)
+	return
+}
+
+func TestWgNet_Resolver(t *testing.T) {
+	_, cli, _ := makeDNSNets(t)
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+
+	ips, err := cli.LookupNetIP(ctx, "ip4", "db.corp.test.")
+	maybeFatal(t, err)
+	if len(ips) != 1 || ips[0] != netip.MustParseAddr("10.131.132.1") {
+		t.Error(ips)
+	}
+	netips, err := cli.LookupIP(ctx, "ip4", "db.corp.test.")
+	maybeFatal(t, err)
+	if len(netips) != 1 || !netips[0].Equal(net.IPv4(10, 131, 132, 1)) {
+		t.Error(netips)
+	}
+	cname, err := cli.LookupCNAME(ctx, "www.corp.test.")
+	maybeFatal(t, err)
+	if cname != "db.corp.test." {
+		t.Error(cname)
+	}
+	_, srvs, err := cli.LookupSRV(ctx, "pg", "tcp", "corp.test.")
+	maybeFatal(t, err)
+	if len(srvs) != 1 || srvs[0].Target != "db.corp.test." || srvs[0].Port != 5432 {
+		t.Error(srvs)
+	}
+	mxs, err := cli.LookupMX(ctx, "corp.test.")
+	maybeFatal(t, err)
+	if len(mxs) != 1 || mxs[0].Host != "mail.corp.test." {
+		t.Error(mxs)
+	}
+	txts, err := cli.LookupTXT(ctx, "corp.test.")
+	maybeFatal(t, err)
+	if len(txts) != 1 || txts[0] != "v=spf1 -all" {
+		t.Error(txts)
+	}
+	names, err := cli.LookupAddr(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	if len(names) != 1 || names[0] != "db.corp.test." {
+		t.Error(names)
+	}
+	if _, err = cli.LookupNetIP(ctx, "ip4", "missing.corp.test."); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWgNet_SearchDomains(t *testing.T) {
+	srv, cli, _ := makeDNSNets(t, "other.test", "corp.test")
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+
+	addrs, err := cli.LookupHost(ctx, "db")
+	maybeFatal(t, err)
+	if len(addrs) != 1 || addrs[0] != "10.131.132.1" {
+		t.Error(addrs)
+	}
+	if _, err = cli.LookupHost(ctx, "db.corp"); err == nil {
+		t.Error("expected error, corp.test is not a search domain of corp")
+	}
+	ips, err := cli.LookupNetIP(ctx, "ip4", "db")
+	maybeFatal(t, err)
+	if len(ips) != 1 || ips[0] != netip.MustParseAddr("10.131.132.1") {
+		t.Error(ips)
+	}
+	cname, err := cli.LookupCNAME(ctx, "www")
+	maybeFatal(t, err)
+	if cname != "db.corp.test." {
+		t.Error(cname)
+	}
+	// the hosts file of the host does not apply in the tunnel
+	if ips, err = cli.LookupNetIP(ctx, "ip4", "localhost"); err == nil {
+		t.Error(ips)
+	}
+
+	l, err := srv.Listen("tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_ = c.Close()
+		}
+	}()
+	c, err := cli.DialContext(ctx, "tcp", "db:80")
+	maybeFatal(t, err)
+	if c.RemoteAddr().String() != "10.131.132.1:80" {
+		t.Error(c.RemoteAddr())
+	}
+	maybeFatal(t, c.Close())
+}
+
+func TestWgNet_Hosts(t *testing.T) {
+	listenPort := nextListenPort
+	nextListenPort++
+	srvCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, listenPort)), nil)
+	maybeFatal(t, err)
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, listenPort)+"\n[Hosts]\nhub.corp.test = 10.131.132.1\n"), nil)
+	maybeFatal(t, err)
+	cliCfg.DNS = nil
+	cliCfg.SearchDomains = []string{"corp.test"}
+	srv := wgnet.New(srvCfg)
+	maybeFatal(t, srv.Open())
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+	cli := wgnet.New(cliCfg)
+	maybeFatal(t, cli.Open())
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+
+	addrs, err := cli.LookupHost(ctx, "HUB.corp.test.")
+	maybeFatal(t, err)
+	if len(addrs) != 1 || addrs[0] != "10.131.132.1" {
+		t.Error(addrs)
+	}
+	ips, err := cli.LookupNetIP(ctx, "ip", "hub")
+	maybeFatal(t, err)
+	if len(ips) != 1 || ips[0] != netip.MustParseAddr("10.131.132.1") {
+		t.Error(ips)
+	}
+	names, err := cli.LookupAddr(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	if len(names) != 1 || names[0] != "hub.corp.test." {
+		t.Error(names)
+	}
+
+	l, err := srv.Listen("tcp", "10.131.132.1:80")
+	maybeFatal(t, err)
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_ = c.Close()
+		}
+	}()
+	c, err := cli.DialContext(ctx, "tcp", "hub:80")
+	maybeFatal(t, err)
+	if c.RemoteAddr().String() != "10.131.132.1:80" {
+		t.Error(c.RemoteAddr())
+	}
+	maybeFatal(t, c.Close())
+}
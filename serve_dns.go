@@ -0,0 +1,236 @@
+package wgnet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSHandler answers DNS queries for ServeDNS. It returns nil to
+// answer with SERVFAIL.
+type DNSHandler interface {
+	ServeDNS(ctx context.Context, req *dnsmessage.Message) (resp *dnsmessage.Message)
+}
+
+// DNSHandlerFunc adapts a function to a DNSHandler.
+type DNSHandlerFunc func(ctx context.Context, req *dnsmessage.Message) (resp *dnsmessage.Message)
+
+func (fn DNSHandlerFunc) ServeDNS(ctx context.Context, req *dnsmessage.Message) *dnsmessage.Message {
+	return fn(ctx, req)
+}
+
+// maxDNSWorkers bounds the UDP queries a DNSServer handles concurrently.
+const maxDNSWorkers = 64
+
+// dnsServerKey is the context key for the WgNet serving a DNS query.
+type dnsServerKey struct{}
+
+// StaticDNSHandler answers queries from Records, and forwards queries for
+// names it has no records for to Forward, if set.
+type StaticDNSHandler struct {
+	Records []dnsmessage.Resource
+	Forward netip.AddrPort // upstream DNS server
+	Dial    DialFunc       // used to reach Forward, default through the tunnel serving the query
+}
+
+func (h *StaticDNSHandler) ServeDNS(ctx context.Context, req *dnsmessage.Message) (resp *dnsmessage.Message) {
+	if len(req.Questions) == 1 {
+		q := req.Questions[0]
+		resp = newDNSResponse(req)
+		resp.Authoritative = true
+		resp.RCode = dnsmessage.RCodeNameError
+		for _, rr := range h.Records {
+			if strings.EqualFold(rr.Header.Name.String(), q.Name.String()) && rr.Header.Class == q.Class {
+				resp.RCode = dnsmessage.RCodeSuccess
+				if rr.Header.Type == q.Type {
+					resp.Answers = append(resp.Answers, rr)
+				}
+			}
+		}
+		if resp.RCode == dnsmessage.RCodeNameError && h.Forward.IsValid() {
+			resp = h.forward(ctx, req)
+		}
+	}
+	return
+}
+
+func (h *StaticDNSHandler) forward(ctx context.Context, req *dnsmessage.Message) (resp *dnsmessage.Message) {
+	dial := h.Dial
+	if dial == nil {
+		if wgnet, ok := ctx.Value(dnsServerKey{}).(*WgNet); ok {
+			dial = wgnet.DialContext
+		} else {
+			var d net.Dialer
+			dial = d.DialContext
+		}
+	}
+	if msg, err := req.Pack(); err == nil {
+		if msg, err = dnsExchange(ctx, dial, h.Forward.String(), msg); err == nil {
+			var m dnsmessage.Message
+			if m.Unpack(msg) == nil {
+				resp = &m
+			}
+		}
+	}
+	return
+}
+
+func newDNSResponse(req *dnsmessage.Message) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               req.ID,
+			Response:         true,
+			OpCode:           req.OpCode,
+			RecursionDesired: req.RecursionDesired,
+		},
+		Questions: req.Questions,
+	}
+}
+
+// DNSServer is a DNS server started by ServeDNS.
+type DNSServer struct {
+	wgnet   *WgNet
+	handler DNSHandler
+	pc      net.PacketConn
+	l       net.Listener
+}
+
+// ServeDNS serves DNS using handler on UDP and TCP at address inside the
+// tunnel. If address is empty, port 53 on the first of the Config addresses
+// is used. Close the returned DNSServer to stop serving.
+func (wgnet *WgNet) ServeDNS(address string, handler DNSHandler) (srv *DNSServer, err error) {
+	if address == "" {
+		err = ErrMissingInterfaceAddress
+		if wgnet != nil && len(wgnet.cfg.Addresses) > 0 {
+			address = netip.AddrPortFrom(wgnet.cfg.Addresses[0].Addr(), 53).String()
+			err = nil
+		}
+	}
+	if err == nil {
+		var pc net.PacketConn
+		if pc, err = wgnet.ListenPacket("udp", address); err == nil {
+			var l net.Listener
+			if l, err = wgnet.Listen("tcp", address); err == nil {
+				srv = &DNSServer{wgnet: wgnet, handler: handler, pc: pc, l: l}
+				go srv.serveUDP()
+				go srv.serveTCP()
+			} else {
+				_ = pc.Close()
+			}
+		}
+	}
+	return
+}
+
+// Close stops the server.
+func (srv *DNSServer) Close() error {
+	return errors.Join(srv.pc.Close(), srv.l.Close())
+}
+
+// handle returns the packed response to msg, limited to maxsize bytes
+// by truncating if needed, or nil if msg is not a valid query.
+func (srv *DNSServer) handle(msg []byte, maxsize int) (b []byte) {
+	var req dnsmessage.Message
+	if req.Unpack(msg) == nil && !req.Response {
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), dnsServerKey{}, srv.wgnet), dnsTimeout)
+		defer cancel()
+		resp := srv.handler.ServeDNS(ctx, &req)
+		if resp == nil {
+			resp = newDNSResponse(&req)
+			resp.RCode = dnsmessage.RCodeServerFailure
+		}
+		resp.ID = req.ID
+		var err error
+		if b, err = resp.Pack(); err == nil && len(b) > maxsize {
+			resp.Truncated = true
+			resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+			b, err = resp.Pack()
+		}
+		if err != nil {
+			b = nil
+		}
+	}
+	return
+}
+
+// udpSize returns the UDP payload size the client accepts.
+func udpSize(msg []byte) (size int) {
+	size = 512
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err == nil && p.SkipAllQuestions() == nil && p.SkipAllAnswers() == nil && p.SkipAllAuthorities() == nil {
+		for {
+			h, err := p.AdditionalHeader()
+			if err != nil {
+				break
+			}
+			if h.Type == dnsmessage.TypeOPT && int(h.Class) > size {
+				size = int(h.Class)
+			}
+			if p.SkipAdditional() != nil {
+				break
+			}
+		}
+	}
+	return
+}
+
+func (srv *DNSServer) serveUDP() {
+	buf := make([]byte, 0xFFFF)
+	workers := make(chan struct{}, maxDNSWorkers)
+	for {
+		n, from, err := srv.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		// wait for a free worker, leaving further queries queued in the socket
+		workers <- struct{}{}
+		go func() {
+			defer func() { <-workers }()
+			if b := srv.handle(msg, udpSize(msg)); b != nil {
+				_, _ = srv.pc.WriteTo(b, from)
+			}
+		}()
+	}
+}
+
+func (srv *DNSServer) serveTCP() {
+	for {
+		c, err := srv.l.Accept()
+		if err != nil {
+			return
+		}
+		go srv.serveConn(c)
+	}
+}
+
+func (srv *DNSServer) serveConn(c net.Conn) {
+	defer c.Close()
+	var lenbuf [2]byte
+	for {
+		if c.SetReadDeadline(time.Now().Add(dnsTimeout*2)) != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, lenbuf[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(lenbuf[:]))
+		if _, err := io.ReadFull(c, msg); err != nil {
+			return
+		}
+		b := srv.handle(msg, 0xFFFF)
+		if b == nil {
+			return
+		}
+		if _, err := c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)); err != nil { // #nosec G115
+			return
+		}
+	}
+}
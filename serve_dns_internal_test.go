@@ -0,0 +1,70 @@
+package wgnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSServer_BoundsUDPWorkers(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var running, peak int
+	release := make(chan struct{})
+	handler := DNSHandlerFunc(func(ctx context.Context, req *dnsmessage.Message) *dnsmessage.Message {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	srv := &DNSServer{handler: handler, pc: pc}
+	go srv.serveUDP()
+	defer pc.Close()
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("x.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}}
+	msg, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range maxDNSWorkers * 2 {
+		if _, err = c.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		mu.Lock()
+		n := running
+		mu.Unlock()
+		if n >= maxDNSWorkers || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 100)
+	mu.Lock()
+	got := peak
+	mu.Unlock()
+	close(release)
+	if got != maxDNSWorkers {
+		t.Fatalf("peak %d concurrent handlers, want %d", got, maxDNSWorkers)
+	}
+}
@@ -0,0 +1,79 @@
+package wgnet_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestWgNet_ServeDNS(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	// the upstream is reached through the tunnel by default
+	upstream, err := cli.ListenPacket("udp", "10.131.132.2:5353")
+	maybeFatal(t, err)
+	defer upstream.Close()
+	go serveFakeDNS(upstream, []dnsmessage.Resource{
+		{Header: dnsHeader("www.example.test.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+	})
+
+	var txts []string
+	for range 20 {
+		txts = append(txts, strings.Repeat("x", 100))
+	}
+	dnssrv, err := srv.ServeDNS("", &wgnet.StaticDNSHandler{
+		Records: []dnsmessage.Resource{
+			{Header: dnsHeader("db.corp.test.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{10, 131, 132, 1}}},
+			{Header: dnsHeader("big.corp.test.", dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: txts}},
+		},
+		Forward: netip.MustParseAddrPort(upstream.LocalAddr().String()),
+	})
+	maybeFatal(t, err)
+	defer dnssrv.Close()
+
+	var networks []string
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			networks = append(networks, network)
+			return cli.DialContext(ctx, network, "10.131.132.1:53")
+		},
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+
+	addrs, err := r.LookupNetIP(ctx, "ip4", "db.corp.test.")
+	maybeFatal(t, err)
+	if len(addrs) != 1 || addrs[0] != netip.MustParseAddr("10.131.132.1") {
+		t.Error(addrs)
+	}
+	addrs, err = r.LookupNetIP(ctx, "ip4", "www.example.test.")
+	maybeFatal(t, err)
+	if len(addrs) != 1 || addrs[0] != netip.MustParseAddr("192.0.2.1") {
+		t.Error(addrs)
+	}
+	networks = nil
+	got, err := r.LookupTXT(ctx, "big.corp.test.")
+	maybeFatal(t, err)
+	if strings.Join(got, "") != strings.Join(txts, "") {
+		t.Error(got)
+	}
+	if len(networks) != 2 || networks[0] != "udp" || networks[1] != "tcp" {
+		t.Error("expected truncated UDP answer and TCP retry, got", networks)
+	}
+	if _, err = r.LookupNetIP(ctx, "ip4", "missing.example.test."); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,156 @@
+package wgnet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/linkdata/deadlock"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+var ErrTunnelDead = errors.New("tunnel dead")
+
+// Supervisor monitors the health of a WgNet and recovers it when the link
+// is dead. A link is dead if pinging PingTarget fails, or if handshakes are
+// stale while we keep sending without receiving anything.
+//
+// On the first failed check, the Supervisor re-resolves Endpoint (if set),
+// points the peer at the result and starts a new handshake. If the link is
+// still dead on the next check, it reopens the WgNet, keeping the
+// re-resolved endpoint.
+type Supervisor struct {
+	WgNet           *WgNet
+	Interval        time.Duration // how often to check, default 10 seconds
+	MaxHandshakeAge time.Duration // handshakes older than this are stale, default device.RejectAfterTime
+	PingTarget      string        // optional IPv4 address to ping through the tunnel
+	PingTimeout     time.Duration // default 5 seconds
+	Endpoint        string        // optional host:port to re-resolve on failure
+	Resolver        *net.Resolver // used to re-resolve Endpoint, default net.DefaultResolver
+	Reopen          func() error  // reopens the WgNet, default WgNet.Open
+	OnRecover       func(reopened bool, err error)
+	mu              deadlock.Mutex // protects following
+	rxBytes         uint64
+	txBytes         uint64
+	endpoint        netip.AddrPort // last re-resolved Endpoint
+}
+
+// Run checks the health of the WgNet every Interval until ctx is done.
+func (s *Supervisor) Run(ctx context.Context) (err error) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if s.Check(ctx) == nil {
+				failures = 0
+			} else if ctx.Err() == nil {
+				failures++
+				reopen := failures > 1
+				if reopen {
+					failures = 0
+					err = s.reopen()
+				} else {
+					err = s.reresolve(ctx)
+				}
+				if s.OnRecover != nil {
+					s.OnRecover(reopen, err)
+				}
+			}
+		}
+	}
+}
+
+// Check returns nil if the link appears healthy, or an error wrapping
+// ErrTunnelDead if not.
+func (s *Supervisor) Check(ctx context.Context) (err error) {
+	if s.PingTarget != "" {
+		timeout := s.PingTimeout
+		if timeout <= 0 {
+			timeout = time.Second * 5
+		}
+		pingctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if _, err = s.WgNet.Ping4(pingctx, s.PingTarget); err != nil {
+			return errors.Join(ErrTunnelDead, err)
+		}
+	}
+	var st *Status
+	if st, err = s.WgNet.Status(); err != nil {
+		return errors.Join(ErrTunnelDead, err)
+	}
+	maxage := s.MaxHandshakeAge
+	if maxage <= 0 {
+		maxage = device.RejectAfterTime
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ps := range st.Peers {
+		if bytes.Equal(ps.PublicKey, s.WgNet.cfg.PublicKey) {
+			sending := ps.TxBytes > s.txBytes && ps.RxBytes == s.rxBytes
+			s.txBytes, s.rxBytes = ps.TxBytes, ps.RxBytes
+			if sending && time.Since(ps.LastHandshake) > maxage {
+				err = fmt.Errorf("%w: last handshake %v", ErrTunnelDead, ps.LastHandshake)
+			}
+		}
+	}
+	return
+}
+
+func (s *Supervisor) reresolve(ctx context.Context) (err error) {
+	if s.Endpoint != "" {
+		var host, port string
+		if host, port, err = net.SplitHostPort(s.Endpoint); err == nil {
+			resolver := s.Resolver
+			if resolver == nil {
+				resolver = net.DefaultResolver
+			}
+			var addrs []netip.Addr
+			if addrs, err = resolver.LookupNetIP(ctx, "ip", host); err == nil {
+				var ap netip.AddrPort
+				if ap, err = netip.ParseAddrPort(net.JoinHostPort(addrs[0].Unmap().String(), port)); err == nil {
+					s.mu.Lock()
+					s.endpoint = ap
+					s.mu.Unlock()
+					err = s.rekey(ap)
+				}
+			}
+		}
+	}
+	return
+}
+
+func (s *Supervisor) reopen() (err error) {
+	s.mu.Lock()
+	s.txBytes, s.rxBytes = 0, 0
+	ap := s.endpoint
+	s.mu.Unlock()
+	if s.Reopen != nil {
+		err = s.Reopen()
+	} else {
+		err = s.WgNet.Open()
+	}
+	if err == nil && ap.IsValid() {
+		err = s.rekey(ap)
+	}
+	return
+}
+
+func (s *Supervisor) rekey(ap netip.AddrPort) (err error) {
+	var dev *device.Device
+	if dev, err = s.WgNet.getdev(); err == nil {
+		err = s.WgNet.rekey(dev, ap)
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package wgnet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/linkdata/wgnet"
+)
+
+func TestSupervisor_Healthy(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	s := &wgnet.Supervisor{WgNet: cli, PingTarget: "10.131.132.1"}
+	maybeFatal(t, s.Check(t.Context()))
+	maybeFatal(t, s.Check(t.Context()))
+}
+
+func TestSupervisor_Recovers(t *testing.T) {
+	cliCfg, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(clientConfig, 9)), nil)
+	maybeFatal(t, err)
+	cli := wgnet.New(cliCfg)
+	maybeFatal(t, cli.Open())
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+
+	var reresolved, reopened atomic.Int32
+	s := &wgnet.Supervisor{
+		WgNet:       cli,
+		Interval:    time.Millisecond * 50,
+		PingTarget:  "10.131.132.1",
+		PingTimeout: time.Millisecond * 50,
+		Endpoint:    "localhost:10",
+	}
+
+	if err = s.Check(t.Context()); !errors.Is(err, wgnet.ErrTunnelDead) {
+		t.Fatalf("expected %v, got %v", wgnet.ErrTunnelDead, err)
+	}
+
+	// stop right after a reopen, which must keep the re-resolved endpoint
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	s.OnRecover = func(reopen bool, err error) {
+		maybeFatal(t, err)
+		if reopen {
+			reopened.Add(1)
+			cancel()
+		} else {
+			reresolved.Add(1)
+		}
+	}
+	if err = s.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Error(err)
+	}
+	if reresolved.Load() == 0 || reopened.Load() == 0 {
+		t.Errorf("reresolved %d, reopened %d", reresolved.Load(), reopened.Load())
+	}
+
+	st, err := cli.Status()
+	maybeFatal(t, err)
+	if ep := st.Peers[0].Endpoint; ep.Port() != 10 || !ep.Addr().IsLoopback() {
+		t.Error(ep)
+	}
+}
+
+func TestSupervisor_Rekeys(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*10)
+	defer cancel()
+	_, err := cli.Ping4(ctx, "10.131.132.1")
+	maybeFatal(t, err)
+	st, err := cli.Status()
+	maybeFatal(t, err)
+	first := st.Peers[0].LastHandshake
+
+	// nothing answers the ping target, so the check fails and the supervisor re-keys
+	rekeyed := make(chan error, 1)
+	s := &wgnet.Supervisor{
+		WgNet:       cli,
+		Interval:    time.Millisecond * 50,
+		PingTarget:  "10.131.132.99",
+		PingTimeout: time.Millisecond * 50,
+		Endpoint:    st.Peers[0].Endpoint.String(),
+		OnRecover: func(reopen bool, err error) {
+			if !reopen {
+				rekeyed <- err
+				cancel()
+			}
+		},
+	}
+	if err = s.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	maybeFatal(t, <-rekeyed)
+
+	timeout := time.After(time.Second * 10)
+	for {
+		st, err = cli.Status()
+		maybeFatal(t, err)
+		if st.Peers[0].LastHandshake.After(first) {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("no new handshake")
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+	if st.Peers[0].PersistentKeepalive != 0 {
+		t.Error(st.Peers[0].PersistentKeepalive)
+	}
+}
@@ -0,0 +1,113 @@
+package wgnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// DefaultUAPIDir is the directory where the wg tool looks for UAPI sockets.
+const DefaultUAPIDir = "/var/run/wireguard"
+
+var ErrInvalidUAPIName = errors.New("invalid UAPI socket name")
+
+func (wgnet *WgNet) getdev() (dev *device.Device, err error) {
+	err = net.ErrClosed
+	if wgnet != nil {
+		wgnet.mu.Lock()
+		if dev = wgnet.dev; dev != nil {
+			err = nil
+		}
+		wgnet.mu.Unlock()
+	}
+	return
+}
+
+// IpcGet returns the UAPI configuration and status of the running device.
+func (wgnet *WgNet) IpcGet() (uapi string, err error) {
+	var dev *device.Device
+	if dev, err = wgnet.getdev(); err == nil {
+		uapi, err = dev.IpcGet()
+	}
+	return
+}
+
+// IpcSet applies the UAPI configuration in uapi to the running device.
+func (wgnet *WgNet) IpcSet(uapi string) (err error) {
+	var dev *device.Device
+	if dev, err = wgnet.getdev(); err == nil {
+		err = dev.IpcSet(uapi)
+	}
+	return
+}
+
+// Status returns the runtime state of the running device.
+func (wgnet *WgNet) Status() (st *Status, err error) {
+	var uapi string
+	if uapi, err = wgnet.IpcGet(); err == nil {
+		st, err = ParseUapiStatus(strings.NewReader(uapi))
+	}
+	return
+}
+
+// Snapshot returns a Config describing the running device, with copies of
+// the addresses, DNS servers, search domains, hosts and log level of the
+// Config used to open it.
+func (wgnet *WgNet) Snapshot() (cfg *Config, err error) {
+	var uapi string
+	if uapi, err = wgnet.IpcGet(); err == nil {
+		if cfg, err = ParseUapi(strings.NewReader(uapi)); err == nil {
+			cfg.Addresses = slices.Clone(wgnet.cfg.Addresses)
+			cfg.DNS = slices.Clone(wgnet.cfg.DNS)
+			cfg.SecureDNS = slices.Clone(wgnet.cfg.SecureDNS)
+			cfg.SearchDomains = slices.Clone(wgnet.cfg.SearchDomains)
+			cfg.Hosts = mergeHosts(wgnet.cfg.Hosts, nil)
+			cfg.LogLevel = wgnet.cfg.LogLevel
+		}
+	}
+	return
+}
+
+// ListenUAPI serves the WireGuard UAPI protocol on the Unix socket
+// dir/name.sock, so that the running device can be inspected and changed
+// using the standard "wg show" and "wg set" commands. Use DefaultUAPIDir
+// for dir to make the socket visible to the wg tool.
+//
+// The device is looked up for each connection, so the listener keeps working
+// across Close and Open. Close the returned listener to stop serving.
+func (wgnet *WgNet) ListenUAPI(dir, name string) (l net.Listener, err error) {
+	err = ErrInvalidUAPIName
+	if name != "" && filepath.Base(name) == name {
+		if err = os.MkdirAll(dir, 0o750); err == nil {
+			sockpath := filepath.Join(dir, name+".sock")
+			if c, dialErr := net.Dial("unix", sockpath); dialErr == nil {
+				_ = c.Close()
+				return nil, os.ErrExist
+			}
+			_ = os.Remove(sockpath)
+			if l, err = net.Listen("unix", sockpath); err == nil {
+				go wgnet.serveUAPI(l)
+			}
+		}
+	}
+	return
+}
+
+func (wgnet *WgNet) serveUAPI(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		if dev, err := wgnet.getdev(); err == nil {
+			go dev.IpcHandle(c)
+		} else {
+			_ = c.Close()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package wgnet_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linkdata/wgnet"
+)
+
+func uapiRequest(t *testing.T, sockpath, req string) (resp string) {
+	t.Helper()
+	c, err := net.Dial("unix", sockpath)
+	maybeFatal(t, err)
+	defer c.Close()
+	_, err = c.Write([]byte(req))
+	maybeFatal(t, err)
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		maybeFatal(t, err)
+		if line == "\n" {
+			return
+		}
+		resp += line
+	}
+}
+
+func TestWgNet_ListenUAPI(t *testing.T) {
+	srv, cli := makeNets()
+	defer func() {
+		maybeFatal(t, cli.Close())
+	}()
+	defer func() {
+		maybeFatal(t, srv.Close())
+	}()
+
+	dir := t.TempDir()
+	l, err := cli.ListenUAPI(dir, "wg0")
+	maybeFatal(t, err)
+	defer l.Close()
+
+	if _, err = cli.ListenUAPI(dir, "wg0"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected %v, got %v", os.ErrExist, err)
+	}
+	if _, err = cli.ListenUAPI(dir, "../wg0"); !errors.Is(err, wgnet.ErrInvalidUAPIName) {
+		t.Errorf("expected %v, got %v", wgnet.ErrInvalidUAPIName, err)
+	}
+
+	sockpath := filepath.Join(dir, "wg0.sock")
+	resp := uapiRequest(t, sockpath, "get=1\n\n")
+	if !strings.Contains(resp, "private_key=") || !strings.HasSuffix(resp, "errno=0\n") {
+		t.Errorf("unexpected get response %q", resp)
+	}
+
+	pubkey := "public_key=" + hex.EncodeToString(decodeKey("Wh3yY7/fE3fyHJ8TOwLJ//CIRbgrlVl4bLQ+npNBSRU="))
+	resp = uapiRequest(t, sockpath, "set=1\n"+pubkey+"\nupdate_only=true\npersistent_keepalive_interval=42\n\n")
+	if resp != "errno=0\n" {
+		t.Errorf("unexpected set response %q", resp)
+	}
+
+	uapi, err := cli.IpcGet()
+	maybeFatal(t, err)
+	if !strings.Contains(uapi, "persistent_keepalive_interval=42\n") {
+		t.Errorf("keepalive not updated\n%s", uapi)
+	}
+}
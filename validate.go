@@ -0,0 +1,122 @@
+package wgnet
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+)
+
+// Severity tells how serious a Problem found by Validate is.
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // the config works, and is probably intended
+	SeverityWarning                 // the config works, but probably not as intended
+	SeverityError                   // the config will not work
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "Severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Problem describes a semantic problem with a Config.
+type Problem struct {
+	Severity Severity
+	Field    string // e.g. "[Peer] AllowedIPs"
+	Message  string
+}
+
+func (p Problem) Error() string {
+	return p.Severity.String() + ": " + p.Field + ": " + p.Message
+}
+
+// Validate checks cfg for semantic mistakes that Parse does not detect,
+// such as duplicate AllowedIPs or an Endpoint routed through the tunnel.
+// It returns nil if no problems were found.
+func (cfg *Config) Validate() (problems []Problem) {
+	add := func(sev Severity, field, format string, args ...any) {
+		problems = append(problems, Problem{Severity: sev, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(cfg.PrivateKey) != 32 {
+		add(SeverityError, "[Interface] PrivateKey", "key length not 32 bytes")
+	}
+	if len(cfg.PublicKey) != 32 {
+		add(SeverityError, "[Peer] PublicKey", "key length not 32 bytes")
+	}
+	if len(cfg.PresharedKey) != 0 && len(cfg.PresharedKey) != 32 {
+		add(SeverityError, "[Peer] PresharedKey", "key length not 32 bytes")
+	}
+	if len(cfg.Addresses) == 0 {
+		add(SeverityError, "[Interface] Address", "no addresses")
+	}
+	for _, pf := range cfg.dropped {
+		add(SeverityWarning, "[Interface] Address", "%s dropped because IPv6 is not allowed", pf)
+	}
+
+	var seen []netip.Prefix
+	for _, pf := range cfg.AllowedIPs {
+		if slices.Contains(seen, pf.Masked()) {
+			add(SeverityWarning, "[Peer] AllowedIPs", "duplicate %s", pf)
+			continue
+		}
+		for _, other := range seen {
+			if pf.Overlaps(other) {
+				add(SeverityWarning, "[Peer] AllowedIPs", "%s overlaps %s", pf, other)
+			}
+		}
+		seen = append(seen, pf.Masked())
+		for _, addr := range cfg.Addresses {
+			// routing the whole interface subnet or more to the peer is intended
+			if host := netip.PrefixFrom(addr.Addr(), addr.Addr().BitLen()); pf.Overlaps(host) && (pf.Bits() > addr.Bits() || pf.IsSingleIP()) {
+				add(SeverityWarning, "[Peer] AllowedIPs", "%s routes the interface address %s to the peer", pf, addr)
+			}
+		}
+		for _, ap := range append([]netip.AddrPort{cfg.Endpoint}, cfg.FallbackEndpoints...) {
+			if ap.IsValid() && pf.Contains(ap.Addr()) {
+				if pf.Bits() == 0 {
+					// full tunnel; wg-quick adds a host route for the endpoint, as must others
+					add(SeverityInfo, "[Peer] Endpoint", "%s is inside default route AllowedIPs %s, it needs a route outside the tunnel", ap.Addr(), pf)
+				} else {
+					add(SeverityWarning, "[Peer] Endpoint", "%s is inside AllowedIPs %s, causing a routing loop", ap.Addr(), pf)
+				}
+			}
+		}
+	}
+
+	if cfg.Endpoint.IsValid() && cfg.ListenPort == 0 && cfg.PersistentKeepalive == 0 {
+		add(SeverityWarning, "[Peer] PersistentKeepalive", "not set, the peer cannot reach us behind NAT once idle")
+	}
+
+	return
+}
+
+// ValidateConfigs validates each of cfgs and additionally checks for
+// problems between them, such as ListenPort collisions.
+func ValidateConfigs(cfgs ...*Config) (problems []Problem) {
+	for i, cfg := range cfgs {
+		problems = append(problems, cfg.Validate()...)
+		if cfg.ListenPort > 0 {
+			for _, other := range cfgs[:i] {
+				if other.ListenPort == cfg.ListenPort {
+					problems = append(problems, Problem{
+						Severity: SeverityError,
+						Field:    "[Interface] ListenPort",
+						Message:  fmt.Sprintf("%d is used by more than one config", cfg.ListenPort),
+					})
+					break
+				}
+			}
+		}
+	}
+	return
+}
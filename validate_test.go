@@ -0,0 +1,88 @@
+package wgnet_test
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/linkdata/wgnet"
+)
+
+func problemStrings(problems []wgnet.Problem) (s []string) {
+	for _, p := range problems {
+		s = append(s, p.Error())
+	}
+	return
+}
+
+func TestConfig_Validate_Clean(t *testing.T) {
+	for _, text := range []string{fmt.Sprintf(serverConfig, 51820), fmt.Sprintf(clientConfig, 51820)} {
+		cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+		maybeFatal(t, err)
+		problems := cfg.Validate()
+		// the client config has no PersistentKeepalive, and a full tunnel
+		for _, p := range problems {
+			if p.Field != "[Peer] PersistentKeepalive" && p.Severity != wgnet.SeverityInfo {
+				t.Error(p)
+			}
+		}
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	text := `
+		[interface]
+		privatekey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		address = 10.0.0.2/24, fd00::2/64
+		[peer]
+		publickey = WDE5QVQyVWxQRWZBUEdldkxMWHRURng5MlVPTlk4M1E=
+		endpoint = 10.0.200.1:51820
+		allowedips = 10.0.0.0/24, 10.0.0.0/24, 10.0.0.0/16, 10.0.0.2/32, 10.0.0.0/30
+	`
+	cfg, err := wgnet.Parse(strings.NewReader(text), nil)
+	maybeFatal(t, err)
+	got := strings.Join(problemStrings(cfg.Validate()), "\n")
+	for _, want := range []string{
+		"warning: [Interface] Address: fd00::2/64 dropped because IPv6 is not allowed",
+		"warning: [Peer] AllowedIPs: duplicate 10.0.0.0/24",
+		"warning: [Peer] AllowedIPs: 10.0.0.0/16 overlaps 10.0.0.0/24",
+		"warning: [Peer] AllowedIPs: 10.0.0.2/32 routes the interface address 10.0.0.2/24 to the peer",
+		"warning: [Peer] AllowedIPs: 10.0.0.0/30 routes the interface address 10.0.0.2/24 to the peer",
+		"warning: [Peer] Endpoint: 10.0.200.1 is inside AllowedIPs 10.0.0.0/16, causing a routing loop",
+		"warning: [Peer] PersistentKeepalive: not set, the peer cannot reach us behind NAT once idle",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+
+	cfg.AllowedIPs = append(cfg.AllowedIPs, netip.MustParsePrefix("0.0.0.0/0"))
+	want := "info: [Peer] Endpoint: 10.0.200.1 is inside default route AllowedIPs 0.0.0.0/0, it needs a route outside the tunnel"
+	if got = strings.Join(problemStrings(cfg.Validate()), "\n"); !strings.Contains(got, want) {
+		t.Errorf("missing %q in\n%s", want, got)
+	}
+
+	if strings.Contains(got, "10.0.0.0/24 routes") || strings.Contains(got, "0.0.0.0/0 routes") {
+		t.Errorf("routing the interface subnet reported in\n%s", got)
+	}
+	if s := (wgnet.Problem{}).Severity; s != wgnet.SeverityInfo || s.String() != "info" {
+		t.Error(s)
+	}
+
+	var empty wgnet.Config
+	if n := len(empty.Validate()); n != 3 {
+		t.Errorf("expected 3 problems, got %v", problemStrings(empty.Validate()))
+	}
+}
+
+func TestValidateConfigs(t *testing.T) {
+	a, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, 51820)), nil)
+	maybeFatal(t, err)
+	b, err := wgnet.Parse(strings.NewReader(fmt.Sprintf(serverConfig, 51820)), nil)
+	maybeFatal(t, err)
+	problems := wgnet.ValidateConfigs(a, b)
+	if len(problems) != 1 || problems[0].Severity != wgnet.SeverityError {
+		t.Errorf("unexpected %v", problemStrings(problems))
+	}
+}
@@ -3,11 +3,15 @@ package wgnet
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"math/rand/v2"
 	"net"
+	"net/http"
 	"net/netip"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/linkdata/deadlock"
@@ -20,11 +24,27 @@ import (
 )
 
 type WgNet struct {
-	cfg *Config // read-only
-	tun tun.Device
-	mu  deadlock.Mutex // protects following
-	dev *device.Device
-	ns  *netstack.Net
+	cfg     *Config // read-only
+	tun     tun.Device
+	capture atomic.Pointer[capturer]
+	fw      atomic.Pointer[firewall]
+	rl      atomic.Pointer[rateLimiter]
+	peers   atomic.Pointer[peerTable]
+	hsfail  atomic.Int64   // handshake failing threshold, see SetHandshakeFailingAfter
+	mu      deadlock.Mutex // protects following
+	dev     *device.Device
+	ns      *netstack.Net
+	stop    chan struct{} // closed to stop the monitor
+	idle    time.Duration // close after this long unused, zero unless created with NewLazy
+	active  int           // number of open tracked connections
+	used    time.Time     // when the netstack was last used
+	hw      *handshakeWatch
+	tlscfg  *tls.Config    // for secure DNS
+	doh     *http.Client   // for DNS-over-HTTPS, created on demand
+	obs     Observer       // receives measurements, if set
+	evmu    deadlock.Mutex // protects following
+	subs    map[int]func(Event)
+	nextsub int
 }
 
 var (
@@ -45,11 +65,21 @@ func New(cfg *Config) *WgNet {
 func (wgnet *WgNet) getnet() (ns *netstack.Net, err error) {
 	err = net.ErrClosed
 	if wgnet != nil {
+		var opened bool
 		wgnet.mu.Lock()
+		if wgnet.ns == nil && wgnet.idle > 0 {
+			if err = wgnet.open(conn.NewDefaultBind()); err == nil {
+				opened = true
+			}
+		}
 		if ns = wgnet.ns; ns != nil {
+			wgnet.used = time.Now()
 			err = nil
 		}
 		wgnet.mu.Unlock()
+		if opened {
+			wgnet.emit(Event{Kind: EventOpened})
+		}
 	}
 	return
 }
@@ -57,11 +87,46 @@ func (wgnet *WgNet) getnet() (ns *netstack.Net, err error) {
 func (wgnet *WgNet) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
 	var ns *netstack.Net
 	if ns, err = wgnet.getnet(); err == nil {
-		conn, err = ns.DialContext(ctx, network, address)
+		start := time.Now()
+		if conn, err = wgnet.dialHost(ctx, ns, network, address); err == nil {
+			conn = wgnet.trackConn(conn)
+		}
+		wgnet.observeDial(network, start, err)
 	}
 	return
 }
 
+// dialHost dials address, resolving host names using the Hosts,
+// SearchDomains and SecureDNS servers of the Config.
+func (wgnet *WgNet) dialHost(ctx context.Context, ns *netstack.Net, network, address string) (conn net.Conn, err error) {
+	if len(wgnet.cfg.SearchDomains)+len(wgnet.cfg.Hosts)+len(wgnet.cfg.SecureDNS) > 0 {
+		host, port := address, ""
+		ping := strings.HasPrefix(network, "ping")
+		if !ping {
+			host, port, err = net.SplitHostPort(address)
+		}
+		if _, e := netip.ParseAddr(host); err == nil && e != nil {
+			var addrs []string
+			if addrs, err = wgnet.lookupHost(ctx, ns, host); err == nil {
+				for _, addr := range addrs {
+					if !ping {
+						addr = net.JoinHostPort(addr, port)
+					}
+					var e error
+					if conn, e = ns.DialContext(ctx, network, addr); e == nil {
+						return conn, nil
+					}
+					if err == nil {
+						err = e
+					}
+				}
+			}
+			return
+		}
+	}
+	return ns.DialContext(ctx, network, address)
+}
+
 func (wgnet *WgNet) Dial(network string, address string) (net.Conn, error) {
 	return wgnet.DialContext(context.Background(), network, address)
 }
@@ -70,7 +135,53 @@ func (wgnet *WgNet) Dial(network string, address string) (net.Conn, error) {
 func (wgnet *WgNet) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
 	var ns *netstack.Net
 	if ns, err = wgnet.getnet(); err == nil {
-		addrs, err = ns.LookupContextHost(ctx, host)
+		start := time.Now()
+		addrs, err = wgnet.lookupHost(ctx, ns, host)
+		if obs := wgnet.observer(); obs != nil {
+			obs.ObserveLookup(host, time.Since(start), err)
+		}
+	}
+	return
+}
+
+// searchNames returns the names to try when looking up host, applying
+// SearchDomains like resolv.conf with ndots:1.
+func (cfg *Config) searchNames(host string) (names []string) {
+	if _, err := netip.ParseAddr(host); err == nil || len(cfg.SearchDomains) == 0 || strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+	dotted := strings.Contains(host, ".")
+	if dotted {
+		names = append(names, host)
+	}
+	for _, domain := range cfg.SearchDomains {
+		names = append(names, host+"."+domain)
+	}
+	if !dotted {
+		names = append(names, host)
+	}
+	return
+}
+
+// lookupHost looks up host in the Hosts of the Config, then using DNS,
+// which is the SecureDNS servers if there are any.
+func (wgnet *WgNet) lookupHost(ctx context.Context, ns *netstack.Net, host string) (addrs []string, err error) {
+	names := wgnet.cfg.searchNames(host)
+	for _, name := range names {
+		if addrs = wgnet.cfg.lookupHosts(name); len(addrs) > 0 {
+			return
+		}
+	}
+	for _, name := range names {
+		if len(wgnet.cfg.SecureDNS) > 0 {
+			addrs, err = wgnet.queryHost(ctx, name)
+		} else {
+			// netstack makes the name absolute itself
+			addrs, err = ns.LookupContextHost(ctx, strings.TrimSuffix(name, "."))
+		}
+		if err == nil {
+			break
+		}
 	}
 	return
 }
@@ -79,6 +190,9 @@ func (wgnet *WgNet) Ping4(ctx context.Context, address string) (latency time.Dur
 	var ns *netstack.Net
 	if ns, err = wgnet.getnet(); err == nil {
 		latency, err = ping4WithDialer(ctx, ns, address)
+		if obs := wgnet.observer(); obs != nil {
+			obs.ObservePing(latency, err)
+		}
 	}
 	return
 }
@@ -127,44 +241,81 @@ func ping4WithDialer(ctx context.Context, dialer contextDialer, address string)
 	return
 }
 
+// Open opens the WireGuard device using the default UDP bind.
 func (wgnet *WgNet) Open() (err error) {
+	return wgnet.OpenBind(conn.NewDefaultBind())
+}
+
+// OpenBind opens the WireGuard device using bind as the outer transport.
+// bind must be non-nil, and is owned by the device once opened.
+func (wgnet *WgNet) OpenBind(bind conn.Bind) (err error) {
 	err = net.ErrClosed
 	if wgnet != nil {
 		_ = wgnet.Close()
 		wgnet.mu.Lock()
-		defer wgnet.mu.Unlock()
-		err = nil
-		var addrs []netip.Addr
-		for _, pf := range wgnet.cfg.Addresses {
-			addrs = append(addrs, pf.Addr())
-		}
-		if wgnet.tun, wgnet.ns, err = netstack.CreateNetTUN(addrs, wgnet.cfg.DNS, 1420); err == nil {
-			wgnet.dev = device.NewDevice(wgnet.tun, conn.NewDefaultBind(), device.NewLogger(wgnet.cfg.LogLevel, "wgnet"))
-			if err = wgnet.dev.IpcSet(wgnet.cfg.UapiConf()); err == nil {
-				err = wgnet.dev.Up()
-			}
+		err = wgnet.open(bind)
+		wgnet.mu.Unlock()
+		if err == nil {
+			wgnet.emit(Event{Kind: EventOpened})
 		}
-		if err != nil {
-			wgnet.tun = nil
-			wgnet.ns = nil
-			if dev := wgnet.dev; dev != nil {
-				wgnet.dev = nil
-				wgnet.close(dev)
+	}
+	return
+}
+
+// open must be called with mu held.
+func (wgnet *WgNet) open(bind conn.Bind) (err error) {
+	var addrs []netip.Addr
+	for _, pf := range wgnet.cfg.Addresses {
+		addrs = append(addrs, pf.Addr())
+	}
+	if wgnet.tun, wgnet.ns, err = netstack.CreateNetTUN(addrs, wgnet.cfg.DNS, tunMTU); err == nil {
+		wgnet.hw = &handshakeWatch{}
+		wgnet.dev = device.NewDevice(&packetTun{Device: wgnet.tun, wgnet: wgnet}, bind, wgnet.hw.logger(wgnet.cfg.LogLevel))
+		if err = wgnet.dev.IpcSet(wgnet.cfg.UapiConf()); err == nil {
+			if err = wgnet.dev.Up(); err == nil {
+				var uapi string
+				if uapi, err = wgnet.dev.IpcGet(); err == nil {
+					var st *Status
+					if st, err = ParseUapiStatus(strings.NewReader(uapi)); err == nil {
+						wgnet.peersPoll(st)
+					}
+				}
 			}
 		}
 	}
+	if err == nil {
+		wgnet.used = time.Now()
+		wgnet.stop = make(chan struct{})
+		go wgnet.monitor(wgnet.dev, wgnet.stop)
+	} else {
+		wgnet.tun = nil
+		wgnet.ns = nil
+		if dev := wgnet.dev; dev != nil {
+			// never served traffic, so there is no load to wait for
+			wgnet.dev = nil
+			dev.Close()
+		}
+	}
 	return
 }
 
 func (wgnet *WgNet) closing() (dev *device.Device) {
 	wgnet.mu.Lock()
+	dev = wgnet.detach()
+	wgnet.mu.Unlock()
+	return
+}
+
+// detach must be called with mu held.
+func (wgnet *WgNet) detach() (dev *device.Device) {
 	if wgnet.ns != nil {
 		dev = wgnet.dev
 		wgnet.tun = nil
 		wgnet.ns = nil
 		wgnet.dev = nil
+		close(wgnet.stop)
+		wgnet.stop = nil
 	}
-	wgnet.mu.Unlock()
 	return
 }
 
@@ -193,18 +344,22 @@ func waitForNoLoad(dev deviceLoad, sleeptime, closetime, maxtime time.Duration)
 
 func (wgnet *WgNet) close(dev *device.Device) {
 	dev.RemoveAllPeers()
-	go waitForNoLoad(dev, time.Millisecond*100, time.Second*10, time.Second*60)
+	go func() {
+		waitForNoLoad(dev, time.Millisecond*100, time.Second*10, time.Second*60)
+		wgnet.emit(Event{Kind: EventReleased})
+	}()
 }
 
 // Close starts asynchronous shutdown of the underlying WireGuard device.
 // It returns after detaching the current netstack, before the device is
 // guaranteed to have released OS resources such as the UDP listen port.
 // Port release is performed in the background after sustained no-load or
-// at the maximum close timeout.
+// at the maximum close timeout, after which EventReleased is emitted.
 func (wgnet *WgNet) Close() (err error) {
 	if wgnet != nil {
 		if dev := wgnet.closing(); dev != nil {
 			wgnet.close(dev)
+			wgnet.emit(Event{Kind: EventClosed})
 		}
 	}
 	return
@@ -218,7 +373,28 @@ func (wgnet *WgNet) Listen(network string, address string) (l net.Listener, err
 			err = ErrUnsupportedNetwork
 			switch network {
 			case "tcp", "tcp4", "tcp6":
-				l, err = ns.ListenTCPAddrPort(addrport)
+				if l, err = ns.ListenTCPAddrPort(addrport); err == nil {
+					l = wgnet.trackListener(l)
+				}
+			}
+		}
+	}
+	return
+}
+
+// ListenPacket listens for UDP datagrams on address inside the tunnel.
+// address must be an IP literal with port.
+func (wgnet *WgNet) ListenPacket(network string, address string) (pc net.PacketConn, err error) {
+	var addrport netip.AddrPort
+	if addrport, err = netip.ParseAddrPort(address); err == nil {
+		var ns *netstack.Net
+		if ns, err = wgnet.getnet(); err == nil {
+			err = ErrUnsupportedNetwork
+			switch network {
+			case "udp", "udp4", "udp6":
+				if pc, err = ns.ListenUDPAddrPort(addrport); err == nil {
+					pc = wgnet.trackPacketConn(pc)
+				}
 			}
 		}
 	}